@@ -1,8 +1,19 @@
 package main
 
 import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"github.com/tappoz/moreping/src/moreping"
@@ -10,13 +21,255 @@ import (
 )
 
 func tcpCmd(c *cli.Context) {
-	moreping.Logger = log.New(os.Stdout, "[TCP stuff] ", log.LstdFlags)
+	moreping.Logger = newLogger(c, "[TCP stuff] ")
+	scheduleProbe(c, moreping.TCPProbe)
+}
 
-	domain := c.String("domain")
-	port := c.Int64("port")
+// The formats of the scheduled results (--output).
+const (
+	textOutput      = "text"
+	ndjsonOutput    = "ndjson"
+	jsonOutput      = "json"
+	csvOutput       = "csv"
+	tsvOutput       = "tsv"
+	influxOutput    = "influx"
+	dashboardOutput = "dashboard"
+	pingOutput      = "ping"
+	syslogOutput    = "syslog"
+)
+
+// textWriter is where the human readable text goes: stdout unless it is taken by a machine readable output
+func textWriter(c *cli.Context) io.Writer {
+	if c.String("output") == textOutput {
+		return os.Stdout
+	}
+	return os.Stderr
+}
+
+func newLogger(c *cli.Context, prefix string) *log.Logger {
+	return log.New(textWriter(c), prefix, log.LstdFlags)
+}
+
+// resultSinks picks the sinks of the scheduled results from the --output flag,
+// plus the history store, the InfluxDB, StatsD and Graphite endpoints, the webhooks, the emails and syslog if any
+func resultSinks(c *cli.Context) []moreping.ResultSink {
+	sinks := []moreping.ResultSink{outputSink(c)}
+	if dir := c.String("history"); dir != "" {
+		store, err := moreping.OpenHistoryStore(dir, c.Duration("retention"))
+		if err != nil {
+			log.Fatalf("Unable to open the history: %s", err)
+		}
+		sinks = append(sinks, store)
+	}
+	if url := c.String("influx-url"); url != "" {
+		sinks = append(sinks, moreping.NewInfluxSink(url, moreping.InfluxOptions{
+			Labels: labels(c),
+			Token:  c.String("influx-token"),
+		}))
+	}
+	if address := c.String("statsd"); address != "" {
+		sink, err := moreping.NewStatsDSink(address, c.String("metric-template"))
+		if err != nil {
+			log.Fatalf("Invalid StatsD address: %s", err)
+		}
+		sinks = append(sinks, sink)
+	}
+	if address := c.String("graphite"); address != "" {
+		sinks = append(sinks, moreping.NewGraphiteSink(address, moreping.GraphiteOptions{Template: c.String("metric-template")}))
+	}
+	if urls := c.StringSlice("webhook"); len(urls) > 0 {
+		sinks = append(sinks, webhookSink(c, urls))
+	}
+	if address := c.String("smtp"); address != "" {
+		sinks = append(sinks, emailSink(c, address))
+	}
+	// with the syslog output it is the output sink already
+	if url := c.String("syslog"); url != "" && c.String("output") != syslogOutput {
+		sinks = append(sinks, syslogSink(c, url))
+	}
+	return sinks
+}
+
+// syslogSink sends the batches and the state changes to a syslog URL
+func syslogSink(c *cli.Context, url string) *moreping.SyslogSink {
+	facility, err := moreping.SyslogFacility(c.String("syslog-facility"))
+	if err != nil {
+		log.Fatalf("Invalid syslog facility: %s", err)
+	}
+	sink, err := moreping.NewSyslogSink(url, moreping.SyslogOptions{Facility: facility})
+	if err != nil {
+		log.Fatalf("Invalid syslog URL: %s", err)
+	}
+	return sink
+}
+
+// webhookSink notifies the state changes to the --webhook URLs
+func webhookSink(c *cli.Context, urls []string) *moreping.WebhookSink {
+	options := moreping.WebhookOptions{Timeout: c.Duration("webhook-timeout"), Retries: c.Int("webhook-retries")}
+	if options.Retries == 0 {
+		options.Retries = -1
+	}
+	options.Template = readTemplate(c, "webhook-template")
+	sink, err := moreping.NewWebhookSink(urls, options)
+	if err != nil {
+		log.Fatalf("Invalid webhook template: %s", err)
+	}
+	return sink
+}
+
+// emailSink emails the state changes through the --smtp server
+func emailSink(c *cli.Context, address string) *moreping.EmailSink {
+	sink, err := moreping.NewEmailSink(moreping.EmailOptions{
+		Address:            address,
+		From:               c.String("smtp-from"),
+		To:                 c.StringSlice("smtp-to"),
+		Username:           c.String("smtp-user"),
+		Password:           c.String("smtp-password"),
+		RequireTLS:         c.Bool("smtp-require-tls"),
+		InsecureSkipVerify: c.Bool("smtp-insecure"),
+		AlertTemplate:      readTemplate(c, "smtp-alert-template"),
+		RecoveryTemplate:   readTemplate(c, "smtp-recovery-template"),
+		RateLimit:          c.Duration("smtp-rate-limit"),
+	})
+	if err != nil {
+		log.Fatalf("Invalid email notifications: %s", err)
+	}
+	return sink
+}
+
+// readTemplate reads the template file of a flag, if any
+func readTemplate(c *cli.Context, flag string) string {
+	file := c.String(flag)
+	if file == "" {
+		return ""
+	}
+	tmpl, err := ioutil.ReadFile(file)
+	if err != nil {
+		log.Fatalf("Unable to read the template of --%s: %s", flag, err)
+	}
+	return string(tmpl)
+}
+
+// labels parses the repeatable --tag key=value flags
+func labels(c *cli.Context) map[string]string {
+	tags := map[string]string{}
+	for _, tag := range c.StringSlice("tag") {
+		sep := strings.Index(tag, "=")
+		if sep <= 0 {
+			log.Fatalf("Invalid tag %q: expected key=value", tag)
+		}
+		tags[tag[:sep]] = tag[sep+1:]
+	}
+	return tags
+}
+
+// waitAndClose blocks until the process is interrupted (or the dashboard is quit),
+// then closes the sinks which buffer the results (e.g. flushing them)
+func waitAndClose(sinks []moreping.ResultSink) {
+	if dashboard, ok := sinks[0].(*moreping.Dashboard); ok {
+		runDashboard(dashboard)
+	} else {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+	}
+	for _, sink := range sinks {
+		if closer, ok := sink.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				log.Printf("Unable to close the sink: %s", err)
+			}
+		}
+	}
+}
+
+// runDashboard draws the dashboard full screen, on every key and every half second, until it is quit
+func runDashboard(dashboard *moreping.Dashboard) {
+	// the log lines would break the screen
+	moreping.Logger = log.New(ioutil.Discard, "", 0)
+	if restore, err := makeRaw(int(os.Stdin.Fd())); err == nil {
+		defer restore()
+	}
+	// the alternate screen, without the cursor
+	fmt.Print("\033[?1049h\033[?25l")
+	defer fmt.Print("\033[?25h\033[?1049l")
+
+	keys := make(chan byte)
+	go func() {
+		key := make([]byte, 1)
+		for {
+			if _, err := os.Stdin.Read(key); err != nil {
+				close(keys)
+				return
+			}
+			keys <- key[0]
+		}
+	}()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
 
-	moreping.Schedule(moreping.TCPBatchFunc([]string{domain}, []int{int(port)}, 10), 5*time.Second)
+	draw := func() {
+		width, height, err := terminalSize(int(os.Stdout.Fd()))
+		if err != nil || width <= 0 || height <= 0 {
+			width, height = 80, 24
+		}
+		dashboard.Render(os.Stdout, width, height)
+	}
 	for {
+		draw()
+		select {
+		case key, ok := <-keys:
+			if !ok {
+				keys = nil // no more keys, e.g. stdin is not a terminal
+			} else if dashboard.HandleKey(key) {
+				return
+			}
+		case <-ticker.C:
+		case <-signals:
+			return
+		}
+	}
+}
+
+// outputSink writes the results to stdout in the format of the --output flag
+func outputSink(c *cli.Context) moreping.ResultSink {
+	switch output := c.String("output"); output {
+	case textOutput:
+		return moreping.NewLoggerSink()
+	case ndjsonOutput:
+		return moreping.NewNDJSONSink(os.Stdout)
+	case jsonOutput:
+		return moreping.NewPrettyJSONSink(os.Stdout)
+	case csvOutput, tsvOutput:
+		options := moreping.CSVOptions{Calls: c.Bool("calls")}
+		if output == tsvOutput {
+			options.Separator = '\t'
+		}
+		if columns := c.String("columns"); columns != "" {
+			options.Columns = strings.Split(columns, ",")
+		}
+		sink, err := moreping.NewCSVSink(os.Stdout, options)
+		if err != nil {
+			log.Fatalf("Invalid CSV output: %s", err)
+		}
+		return sink
+	case influxOutput:
+		return moreping.NewInfluxWriterSink(os.Stdout, labels(c))
+	case dashboardOutput:
+		return moreping.NewDashboard(0)
+	case syslogOutput:
+		url := c.String("syslog")
+		if url == "" {
+			url = "unix:///dev/log"
+		}
+		// the log messages go to syslog as well, instead of stdout
+		sink := syslogSink(c, url)
+		moreping.Logger = sink
+		return sink
+	default:
+		log.Fatalf("Unknown output format: %s (available: text, ndjson, json, csv, tsv, influx, dashboard, ping, syslog)", output)
+		return nil
 	}
 }
 
@@ -29,13 +282,522 @@ func sudoCheck() {
 }
 
 func icmpCmd(c *cli.Context) {
-	moreping.Logger = log.New(os.Stdout, "[ICMP stuff] ", log.LstdFlags)
+	moreping.Logger = newLogger(c, "[ICMP stuff] ")
+	sudoCheck()
+	scheduleProbe(c, moreping.IcmpProbe)
+}
+
+func probeCmd(c *cli.Context) {
+	probeType := c.String("type")
+	moreping.Logger = newLogger(c, fmt.Sprintf("[%s stuff] ", probeType))
+	scheduleProbe(c, probeType)
+}
+
+// scheduleProbe schedules the batches of calls of any probe type until the process is killed
+func scheduleProbe(c *cli.Context, probeType string) {
+	config, err := probeConfig(c)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %s", err)
+	}
+	if c.String("output") == pingOutput && config.ICMP.Size == 0 {
+		// the raw socket calls (with any ICMP option set) tell the TTL of the replies
+		config.ICMP.Size = 56
+	}
+	prober, err := moreping.NewProber(probeType, config)
+	if err != nil {
+		log.Fatalf("Unable to create the prober: %s", err)
+	}
+	ips, err := expandDomains(c)
+	if err != nil {
+		log.Fatalf("Invalid targets: %s", err)
+	}
+	targets := moreping.Targets(ips, []int{int(c.Int64("port"))})
+	if c.String("output") == pingOutput {
+		runPing(c, prober, targets)
+		return
+	}
+
+	sinks := resultSinks(c)
+	moreping.Schedule(moreping.ProbeBatchFunc(prober, targets, c.Int("batch"), moreping.NewMultiSink(sinks...)), c.Duration("interval"))
+	waitAndClose(sinks)
+}
+
+// runPing probes a single target printing the calls as ping does, until the --count calls are done
+// or the process is interrupted, and exits with 1 if no call was successful (as ping)
+func runPing(c *cli.Context, prober moreping.Prober, targets []moreping.Target) {
+	if len(targets) != 1 {
+		log.Fatalf("The ping output needs a single target, %d in %q", len(targets), c.String("domain"))
+	}
+	// as ping, the host name is resolved once
+	target := targets[0]
+	if net.ParseIP(target.IpAddress) == nil {
+		addresses, err := net.LookupHost(target.IpAddress)
+		if err != nil {
+			log.Fatalf("Unable to resolve %s: %s", target.IpAddress, err)
+		}
+		target.IpAddress = addresses[0]
+	}
+	// and there is a call per second by default
+	interval := time.Second
+	if c.IsSet("interval") {
+		interval = c.Duration("interval")
+	}
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		close(stop)
+	}()
+	session := moreping.NewPingSession(os.Stdout, prober, c.String("domain"), target, c.Int("size"))
+	if !session.Run(c.Int("count"), interval, stop) {
+		os.Exit(1)
+	}
+}
+
+func serveCmd(c *cli.Context) {
+	moreping.Logger = newLogger(c, "[Serve stuff] ")
+	config, err := probeConfig(c)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %s", err)
+	}
+	ips, err := expandDomains(c)
+	if err != nil {
+		log.Fatalf("Invalid targets: %s", err)
+	}
+
+	metrics := moreping.NewPrometheusSink()
+	sinks := append(resultSinks(c), metrics)
+	// a single multi sink, so that the batches of all the probe types are written one at a time
+	sink := moreping.NewMultiSink(sinks...)
+	for _, probeType := range strings.Split(c.String("type"), ",") {
+		prober, err := moreping.NewProber(strings.TrimSpace(probeType), config)
+		if err != nil {
+			log.Fatalf("Unable to create the prober: %s", err)
+		}
+		ports := []int{int(c.Int64("port"))}
+		if prober.Name() == moreping.IcmpProbe {
+			ports = nil
+		}
+		targets := moreping.Targets(ips, ports)
+		moreping.Schedule(moreping.ProbeBatchFunc(prober, targets, c.Int("batch"), sink), c.Duration("interval"))
+	}
+
+	http.Handle("/metrics", metrics)
+	moreping.Logger.Printf("Serving the metrics on http://%s/metrics\n", c.String("listen"))
+	go func() {
+		log.Fatal(http.ListenAndServe(c.String("listen"), nil))
+	}()
+	waitAndClose(sinks)
+}
+
+// expandDomains expands the comma separated domains (and exclusions) of the command line
+func expandDomains(c *cli.Context) ([]string, error) {
+	exclusions := []string{}
+	if exclude := c.String("exclude"); exclude != "" {
+		exclusions = strings.Split(exclude, ",")
+	}
+	ips, err := moreping.ExpandTargets(strings.Split(c.String("domain"), ","), exclusions)
+	if err == nil && len(ips) == 0 {
+		err = fmt.Errorf("no targets in %q", c.String("domain"))
+	}
+	return ips, err
+}
+
+func discoverCmd(c *cli.Context) {
+	probeType := c.String("type")
+	moreping.Logger = newLogger(c, "[Discovery stuff] ")
+	config, err := probeConfig(c)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %s", err)
+	}
+	prober, err := moreping.NewProber(probeType, config)
+	if err != nil {
+		log.Fatalf("Unable to create the prober: %s", err)
+	}
+	ips, err := expandDomains(c)
+	if err != nil {
+		log.Fatalf("Invalid targets: %s", err)
+	}
+	var ports []int
+	if probeType != moreping.IcmpProbe {
+		ports, err = moreping.ParsePorts(c.String("ports"))
+		if err != nil {
+			log.Fatalf("Invalid ports: %s", err)
+		}
+	}
+
+	aliveIPs := moreping.DiscoverHosts(prober, ips, ports, c.Int("concurrency"))
+	report := textWriter(c)
+	for _, ip := range aliveIPs {
+		fmt.Fprintf(report, "%s is alive\n", ip)
+	}
+	fmt.Fprintf(report, "\nmoreping done: %d hosts probed, %d alive\n", len(ips), len(aliveIPs))
+	if !c.Bool("schedule") || len(aliveIPs) == 0 {
+		return
+	}
 
+	sinks := resultSinks(c)
+	moreping.Schedule(moreping.ProbeBatchFunc(prober, moreping.Targets(aliveIPs, ports), c.Int("batch"), moreping.NewMultiSink(sinks...)), c.Duration("interval"))
+	waitAndClose(sinks)
+}
+
+// probeConfig builds the configuration of the probers from the command line flags
+func probeConfig(c *cli.Context) (moreping.ProbeConfig, error) {
+	config := moreping.ProbeConfig{Timeout: c.Duration("timeout")}
+
+	config.TCP.SynMode = c.Bool("syn")
+	config.TCP.Proxy = c.String("proxy")
+
+	for _, spec := range c.StringSlice("step") {
+		step, err := moreping.ParseTcpStep(spec)
+		if err != nil {
+			return config, err
+		}
+		config.TCP.Script = append(config.TCP.Script, step)
+	}
+
+	config.ICMP = moreping.IcmpOptions{
+		Size: c.Int("size"),
+		TTL:  c.Int("ttl"),
+		TOS:  c.Int("tos"),
+	}
+	if c.IsSet("dscp") {
+		config.ICMP.TOS = moreping.DSCP(c.Int("dscp"))
+	}
+	if pattern := c.String("pattern"); pattern != "" {
+		patternBytes, err := hex.DecodeString(pattern)
+		if err != nil {
+			return config, fmt.Errorf("invalid payload pattern %s: %s", pattern, err)
+		}
+		config.ICMP.Pattern = patternBytes
+	}
+
+	source := moreping.SourceOptions{
+		IP:        c.String("source-ip"),
+		Interface: c.String("interface"),
+		Mark:      c.Int("mark"),
+	}
+	config.TCP.Source = source
+	config.ICMP.Source = source
+	config.Netns = c.String("netns")
+	return config, nil
+}
+
+func historyCmd(c *cli.Context) {
+	history := loadHistory(c)
+	if c.String("output") == textOutput {
+		renderHistory(os.Stdout, history, c.Bool("calls"))
+		return
+	}
+	sink := outputSink(c)
+	for _, call := range history.Calls {
+		sink.WriteResult(call)
+	}
+	for _, batch := range history.Batches {
+		sink.WriteBatch(batch)
+	}
+}
+
+func reportCmd(c *cli.Context) {
+	history := loadHistory(c)
+	var w io.Writer = os.Stdout
+	if file := c.String("file"); file != "-" {
+		f, err := os.Create(file)
+		if err != nil {
+			log.Fatalf("Unable to create the report: %s", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := moreping.WriteHTMLReport(w, history, moreping.ReportOptions{Title: c.String("title")}); err != nil {
+		log.Fatalf("Unable to write the report: %s", err)
+	}
+	if file := c.String("file"); file != "-" {
+		fmt.Fprintf(os.Stderr, "Report of %d batches written to %s\n", len(history.Batches), file)
+	}
+}
+
+func smokeCmd(c *cli.Context) {
+	format := c.String("format")
+	write := map[string]func(io.Writer, []moreping.ProbeBatch, moreping.SmokeOptions) error{
+		"svg": moreping.WriteSmokeSVG,
+		"png": moreping.WriteSmokePNG,
+	}[format]
+	if write == nil {
+		log.Fatalf("Unknown graph format: %s (available: svg, png)", format)
+	}
+
+	history := loadHistory(c)
+	for _, summary := range moreping.SummarizeBatches(history.Batches) {
+		batches := []moreping.ProbeBatch{}
+		for _, batch := range history.Batches {
+			if batch.Probe == summary.Probe && batch.IpAddress == summary.IpAddress && batch.Port == summary.Port {
+				batches = append(batches, batch)
+			}
+		}
+		title := fmt.Sprintf("%s %s", summary.Probe, summary.IpAddress)
+		name := fmt.Sprintf("smoke-%s-%s", summary.Probe, strings.Replace(summary.IpAddress, ":", "_", -1))
+		if summary.Port != 0 {
+			title += fmt.Sprintf(":%d", summary.Port)
+			name += fmt.Sprintf("-%d", summary.Port)
+		}
+		file := filepath.Join(c.String("dir"), name+"."+format)
+		f, err := os.Create(file)
+		if err != nil {
+			log.Fatalf("Unable to create the graph: %s", err)
+		}
+		err = write(f, batches, moreping.SmokeOptions{
+			Title:      title,
+			Width:      c.Int("width"),
+			Height:     c.Int("height"),
+			MaxLatency: c.Duration("max-latency"),
+		})
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			log.Fatalf("Unable to write %s: %s", file, err)
+		}
+		fmt.Printf("%s: %d batches\n", file, len(batches))
+	}
+}
+
+// loadHistory reads the records selected by the command line flags
+// from the history store and from the NDJSON recordings
+func loadHistory(c *cli.Context) moreping.History {
+	filter, err := historyFilter(c, time.Now())
+	if err != nil {
+		log.Fatalf("Invalid filter: %s", err)
+	}
+	dir, inputs := c.String("history"), c.StringSlice("input")
+	if dir == "" && len(inputs) == 0 {
+		log.Fatal("The directory of the history (--history) or a recording (--input) is required")
+	}
+
+	history := moreping.History{}
+	if dir != "" {
+		// no retention here, the store is only read
+		store, err := moreping.OpenHistoryStore(dir, 0)
+		if err != nil {
+			log.Fatalf("Unable to open the history: %s", err)
+		}
+		if history, err = store.Query(filter); err != nil {
+			log.Fatalf("Unable to query the history: %s", err)
+		}
+	}
+	for _, input := range inputs {
+		f, err := os.Open(input)
+		if err != nil {
+			log.Fatalf("Unable to open the recording: %s", err)
+		}
+		err = moreping.ReadHistory(f, filter, &history)
+		f.Close()
+		if err != nil {
+			log.Fatalf("Unable to read %s: %s", input, err)
+		}
+	}
+	history.Sort()
+	return history
+}
+
+// historyFilter builds the filter of the records from the command line flags
+func historyFilter(c *cli.Context, now time.Time) (moreping.HistoryFilter, error) {
+	var err error
+	filter := moreping.HistoryFilter{Probe: c.String("type"), Port: int(c.Int64("port"))}
+	if domain := c.String("domain"); domain != "" {
+		if filter.Targets, err = moreping.ExpandTargets(strings.Split(domain, ","), nil); err != nil {
+			return filter, err
+		}
+	}
+	if filter.From, err = parseTime(c.String("from"), now); err != nil {
+		return filter, fmt.Errorf("invalid start time: %s", err)
+	}
+	if filter.To, err = parseTime(c.String("to"), now); err != nil {
+		return filter, fmt.Errorf("invalid end time: %s", err)
+	}
+	return filter, nil
+}
+
+// parseTime parses a duration ago, a RFC 3339 time or a (local) date, the zero time if empty
+func parseTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if ago, err := time.ParseDuration(value); err == nil {
+		return now.Add(-ago), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, time.Local)
+}
+
+// renderHistory prints the calls or the batches, then the summary of every target
+func renderHistory(w io.Writer, history moreping.History, showCalls bool) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if showCalls {
+		fmt.Fprintln(tw, "TIME\tPROBE\tTARGET\tPORT\tRESULT\tLATENCY\tMESSAGE")
+		for _, call := range history.Calls {
+			result, latency := "ok", millis(call.Latency)
+			if !call.Success {
+				result, latency = "failed", "-"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n", call.Timestamp.Local().Format("2006-01-02 15:04:05"),
+				call.Probe, call.IpAddress, call.Port, result, latency, call.Message)
+		}
+	} else {
+		fmt.Fprintln(tw, "TIME\tPROBE\tTARGET\tPORT\tSENT\tLOSS%\tAVG\tMIN\tMAX")
+		for _, batch := range history.Batches {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\n", batch.Timestamp.Local().Format("2006-01-02 15:04:05"),
+				batch.Probe, batch.IpAddress, batch.Port, batchStats(batch))
+		}
+	}
+	tw.Flush()
+
+	summaries := moreping.SummarizeBatches(history.Batches)
+	if len(summaries) == 0 {
+		fmt.Fprintln(w, "\nNo batches in the history for this time range")
+		return
+	}
+	fmt.Fprintln(w, "\n--- summary ---")
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "LAST\tPROBE\tTARGET\tPORT\tSENT\tLOSS%\tAVG\tMIN\tMAX")
+	for _, summary := range summaries {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\n", summary.Timestamp.Local().Format("2006-01-02 15:04:05"),
+			summary.Probe, summary.IpAddress, summary.Port, batchStats(summary))
+	}
+	tw.Flush()
+}
+
+// batchStats formats the calls, the loss and the latencies (in milliseconds) of a batch as table cells
+func batchStats(batch moreping.ProbeBatch) string {
+	minLatency, maxLatency := "-", "-"
+	if len(batch.Latencies) > 0 {
+		minLatency, maxLatency = millis(batch.MinLatency), millis(batch.MaxLatency)
+	}
+	return fmt.Sprintf("%d\t%.1f\t%s\t%s\t%s", batch.Experiments, batch.PctPcktLoss*100, millis(batch.AvgLatency), minLatency, maxLatency)
+}
+
+func watchCmd(c *cli.Context) {
 	sudoCheck()
 	domain := c.String("domain")
+	ipAddr, err := net.ResolveIPAddr("ip4", domain)
+	if err != nil {
+		log.Fatalf("Unable to resolve %s: %s", domain, err)
+	}
+	targetIP := ipAddr.String()
 
-	moreping.Schedule(moreping.IcmpBatchFunc([]string{domain}, 10), 5*time.Second)
+	hopStatsChan := make(chan []moreping.HopStats)
+	hopWatcher := moreping.NewHopWatcher(targetIP, c.Int("max-hops"), c.Duration("timeout"), hopStatsChan)
+	moreping.Schedule(hopWatcher.ProbeHops, c.Duration("interval"))
 
-	for {
+	for hopStats := range hopStatsChan {
+		// clear the terminal and redraw the whole table
+		fmt.Print("\033[H\033[2J")
+		renderHops(os.Stdout, domain, targetIP, hopStats)
+	}
+}
+
+func pmtuCmd(c *cli.Context) {
+	sudoCheck()
+	domain := c.String("domain")
+	ipAddr, err := net.ResolveIPAddr("ip4", domain)
+	if err != nil {
+		log.Fatalf("Unable to resolve %s: %s", domain, err)
+	}
+
+	pathMTU, err := moreping.DiscoverPathMTU(ipAddr.String(), c.Int("max-mtu"), c.Duration("timeout"))
+	if err != nil {
+		log.Fatalf("Unable to discover the path MTU to %s: %s", domain, err)
 	}
+	fmt.Printf("Path MTU to %s (%s): %d bytes (%d probes)\n", domain, pathMTU.IpAddress, pathMTU.PathMTU, pathMTU.Probes)
+	for _, fragNeeded := range pathMTU.FragNeeded {
+		from := fragNeeded.IpAddress
+		if from == "" {
+			from = "local host"
+		}
+		fmt.Printf("  fragmentation needed from %s, next hop MTU: %d\n", from, fragNeeded.NextHopMTU)
+	}
+}
+
+func scanCmd(c *cli.Context) {
+	domain := c.String("domain")
+	ipAddr, err := net.ResolveIPAddr("ip4", domain)
+	if err != nil {
+		log.Fatalf("Unable to resolve %s: %s", domain, err)
+	}
+
+	var ports []int
+	if spec := c.String("ports"); spec != "" {
+		ports, err = moreping.ParsePorts(spec)
+		if err != nil {
+			log.Fatalf("Invalid ports: %s", err)
+		}
+	} else {
+		if c.Int("top") < 1 {
+			log.Fatalf("Invalid --top %d: expected a positive number of ports", c.Int("top"))
+		}
+		ports = moreping.TopPorts(c.Int("top"))
+	}
+
+	start := time.Now()
+	scanner := moreping.NewPortScanner(c.Duration("timeout"), moreping.TCPOptions{SynMode: c.Bool("syn"), Proxy: c.String("proxy")}, c.Int("concurrency"))
+	portScans := scanner.ScanIP(ipAddr.String(), ports)
+	renderPortScans(os.Stdout, domain, ipAddr.String(), portScans, c.Bool("all"))
+	fmt.Printf("\nmoreping done: %d ports scanned in %.2f seconds\n", len(ports), time.Now().Sub(start).Seconds())
+}
+
+// renderPortScans prints an nmap-like summary: unless all the ports are requested,
+// the most common closed/filtered state is summarized in a single line.
+func renderPortScans(w io.Writer, domain string, targetIP string, portScans []moreping.PortScan, showAll bool) {
+	fmt.Fprintf(w, "moreping scan report for %s (%s)\n", domain, targetIP)
+	counts := map[moreping.PortState]int{}
+	for _, portScan := range portScans {
+		counts[portScan.State]++
+	}
+	hidden := moreping.PortState("")
+	if !showAll {
+		hidden = moreping.PortClosed
+		if counts[moreping.PortFiltered] > counts[moreping.PortClosed] {
+			hidden = moreping.PortFiltered
+		}
+		if counts[hidden] > 0 {
+			fmt.Fprintf(w, "Not shown: %d %s ports\n", counts[hidden], hidden)
+		}
+	}
+	if counts[hidden] == len(portScans) {
+		fmt.Fprintf(w, "All %d scanned ports are %s\n", len(portScans), hidden)
+		return
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+	fmt.Fprintln(tw, "PORT\tSTATE\tSERVICE")
+	for _, portScan := range portScans {
+		if portScan.State == hidden {
+			continue
+		}
+		fmt.Fprintf(tw, "%d/tcp\t%s\t%s\n", portScan.TcpPort, portScan.State, portScan.Service)
+	}
+	tw.Flush()
+}
+
+func renderHops(w io.Writer, domain string, targetIP string, hopStats []moreping.HopStats) {
+	fmt.Fprintf(w, "moreping watch %s (%s) %s\n\n", domain, targetIP, time.Now().Format(time.RFC1123))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "Hop\tHost\tLoss%\tSnt\tLast\tAvg\tBest\tWrst\tStDev\t")
+	for _, hop := range hopStats {
+		host := hop.IpAddress
+		if host == "" {
+			host = "???"
+		}
+		fmt.Fprintf(tw, "%d.\t%s\t%.1f%%\t%d\t%s\t%s\t%s\t%s\t%s\t\n",
+			hop.Hop, host, hop.PctPcktLoss*100, hop.Sent,
+			millis(hop.Last), millis(hop.AvgLatency), millis(hop.Best), millis(hop.Worst), millis(hop.StdDev))
+	}
+	tw.Flush()
+}
+
+func millis(d time.Duration) string {
+	return fmt.Sprintf("%.1f", float64(d)/float64(time.Millisecond))
 }
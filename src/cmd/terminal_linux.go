@@ -0,0 +1,43 @@
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// makeRaw disables the echo, the line buffering and the signals (Ctrl-C comes as a key) of the terminal,
+// returning the function restoring it
+func makeRaw(fd int) (func(), error) {
+	var termios syscall.Termios
+	if err := ioctl(fd, syscall.TCGETS, unsafe.Pointer(&termios)); err != nil {
+		return nil, err
+	}
+	raw := termios
+	raw.Lflag &^= syscall.ECHO | syscall.ICANON | syscall.ISIG
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	if err := ioctl(fd, syscall.TCSETS, unsafe.Pointer(&raw)); err != nil {
+		return nil, err
+	}
+	return func() {
+		ioctl(fd, syscall.TCSETS, unsafe.Pointer(&termios))
+	}, nil
+}
+
+// terminalSize is the number of columns and rows of the terminal
+func terminalSize(fd int) (int, int, error) {
+	var size struct {
+		rows, cols, xPixels, yPixels uint16
+	}
+	if err := ioctl(fd, syscall.TIOCGWINSZ, unsafe.Pointer(&size)); err != nil {
+		return 0, 0, err
+	}
+	return int(size.cols), int(size.rows), nil
+}
+
+func ioctl(fd int, request uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), request, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
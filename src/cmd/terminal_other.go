@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+var errTerminalUnsupported = errors.New("terminal control is only supported on Linux")
+
+// makeRaw is not supported: the keys of the dashboard need Enter
+func makeRaw(fd int) (func(), error) {
+	return nil, errTerminalUnsupported
+}
+
+// terminalSize is not supported: the dashboard assumes 80x24
+func terminalSize(fd int) (int, int, error) {
+	return 0, 0, errTerminalUnsupported
+}
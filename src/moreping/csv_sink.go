@@ -0,0 +1,180 @@
+package moreping
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// detailsColumnPrefix selects a probe specific detail as a column, e.g. details.port_state
+const detailsColumnPrefix = "details."
+
+// The columns of the CSV rows.
+var (
+	// CallColumns are all the columns available for the calls (besides the details)
+	CallColumns = []string{"timestamp", "probe", "target", "port", "success", "latency_ms", "message"}
+	// BatchColumns are all the columns available for the batches
+	BatchColumns = []string{"timestamp", "probe", "target", "port", "experiments", "loss_ratio", "avg_latency_ms", "min_latency_ms", "max_latency_ms", "failures"}
+)
+
+// CSVOptions holds the format of the CSV sink. The zero value writes
+// the batches with all their columns, separated by commas.
+type CSVOptions struct {
+	Separator rune     // ',' by default, '\t' for TSV
+	Calls     bool     // write the single calls instead of the batches
+	Columns   []string // the columns (and their order), all of them by default
+}
+
+// CSVSink is a result sink writing either the calls or the batches as CSV (or TSV) rows,
+// preceded by a header row.
+type CSVSink struct {
+	mutex         sync.Mutex
+	writer        *csv.Writer
+	options       CSVOptions
+	headerWritten bool
+}
+
+// NewCSVSink creates a new CSV sink, refusing the unknown columns.
+func NewCSVSink(w io.Writer, options CSVOptions) (*CSVSink, error) {
+	available := BatchColumns
+	if options.Calls {
+		available = CallColumns
+	}
+	if len(options.Columns) == 0 {
+		options.Columns = available
+	}
+	for _, column := range options.Columns {
+		if options.Calls && strings.HasPrefix(column, detailsColumnPrefix) {
+			continue
+		}
+		if !containsString(available, column) {
+			return nil, fmt.Errorf("unknown column: %s (available: %s)", column, strings.Join(available, ", "))
+		}
+	}
+	writer := csv.NewWriter(w)
+	if options.Separator != 0 {
+		writer.Comma = options.Separator
+	}
+	return &CSVSink{writer: writer, options: options}, nil
+}
+
+// WriteResult writes a row for the call (unless the sink writes the batches).
+func (c *CSVSink) WriteResult(result ProbeResult) error {
+	if !c.options.Calls {
+		return nil
+	}
+	return c.writeRow(func(column string) string {
+		switch column {
+		case "timestamp":
+			return result.Timestamp.Format(time.RFC3339Nano)
+		case "probe":
+			return result.Probe
+		case "target":
+			return result.IpAddress
+		case "port":
+			return strconv.Itoa(result.Port)
+		case "success":
+			return strconv.FormatBool(result.Success)
+		case "latency_ms":
+			if !result.Success {
+				return ""
+			}
+			return formatMilliseconds(result.Latency)
+		case "message":
+			return result.Message
+		}
+		return result.Details[strings.TrimPrefix(column, detailsColumnPrefix)]
+	})
+}
+
+// WriteBatch writes a row for the batch (unless the sink writes the calls).
+func (c *CSVSink) WriteBatch(batch ProbeBatch) error {
+	if c.options.Calls {
+		return nil
+	}
+	return c.writeRow(func(column string) string {
+		switch column {
+		case "timestamp":
+			return batch.Timestamp.Format(time.RFC3339Nano)
+		case "probe":
+			return batch.Probe
+		case "target":
+			return batch.IpAddress
+		case "port":
+			return strconv.Itoa(batch.Port)
+		case "experiments":
+			return strconv.Itoa(batch.Experiments)
+		case "loss_ratio":
+			return strconv.FormatFloat(float64(batch.PctPcktLoss), 'f', -1, 32)
+		case "avg_latency_ms":
+			return formatMilliseconds(batch.AvgLatency)
+		case "min_latency_ms":
+			if len(batch.Latencies) == 0 {
+				return ""
+			}
+			return formatMilliseconds(batch.MinLatency)
+		case "max_latency_ms":
+			if len(batch.Latencies) == 0 {
+				return ""
+			}
+			return formatMilliseconds(batch.MaxLatency)
+		case "failures":
+			return formatFailures(batch.Failures)
+		}
+		return ""
+	})
+}
+
+// writeRow writes the header row first (once), then the values of the columns,
+// flushing every row so that the output can be followed while the probes run.
+func (c *CSVSink) writeRow(value func(column string) string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.headerWritten {
+		if err := c.writer.Write(c.options.Columns); err != nil {
+			return err
+		}
+		c.headerWritten = true
+	}
+	row := make([]string, len(c.options.Columns))
+	for idx, column := range c.options.Columns {
+		row[idx] = value(column)
+	}
+	if err := c.writer.Write(row); err != nil {
+		return err
+	}
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+func formatMilliseconds(d time.Duration) string {
+	return strconv.FormatFloat(milliseconds(d), 'f', -1, 64)
+}
+
+// formatFailures formats the failures as "message: count" pairs separated by semicolons.
+func formatFailures(failures map[string]int) string {
+	messages := make([]string, 0, len(failures))
+	for message := range failures {
+		messages = append(messages, message)
+	}
+	sort.Strings(messages)
+	pairs := make([]string, 0, len(messages))
+	for _, message := range messages {
+		pairs = append(pairs, fmt.Sprintf("%s: %d", message, failures[message]))
+	}
+	return strings.Join(pairs, "; ")
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
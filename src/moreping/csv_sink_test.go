@@ -0,0 +1,57 @@
+package moreping_test
+
+import (
+	"bytes"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/tappoz/moreping/src/moreping"
+)
+
+var _ = Describe("CSV output", func() {
+
+	timestamp := time.Date(2017, time.July, 14, 2, 40, 0, 0, time.UTC)
+
+	It("should write the batches with a header row", func() {
+		output := &bytes.Buffer{}
+		sink, err := moreping.NewCSVSink(output, moreping.CSVOptions{})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(sink.WriteResult(moreping.ProbeResult{Probe: "tcp"})).To(Succeed())
+		Expect(sink.WriteBatch(moreping.ProbeBatch{
+			Probe: "tcp", IpAddress: "10.0.0.1", Port: 22, Timestamp: timestamp, Experiments: 4, PctPcktLoss: 0.25,
+			AvgLatency: 2500 * time.Microsecond, MinLatency: time.Millisecond, MaxLatency: 4 * time.Millisecond,
+			Latencies: []time.Duration{time.Millisecond, 4 * time.Millisecond}, Failures: map[string]int{"i/o timeout": 1, "refused, again": 1},
+		})).To(Succeed())
+		Expect(moreping.NewLegacySink(sink).WriteIcmpBatch(moreping.IcmpBatch{IpAddress: "10.0.0.2", Expertiments: 2, PctPcktLoss: 1, AvgLatency: moreping.InfiniteLatency})).To(Succeed())
+
+		lines := bytes.Split(bytes.TrimSpace(output.Bytes()), []byte("\n"))
+		Expect(lines).To(HaveLen(3))
+		Expect(string(lines[0])).To(Equal("timestamp,probe,target,port,experiments,loss_ratio,avg_latency_ms,min_latency_ms,max_latency_ms,failures"))
+		Expect(string(lines[1])).To(Equal(`2017-07-14T02:40:00Z,tcp,10.0.0.1,22,4,0.25,2.5,1,4,"i/o timeout: 1; refused, again: 1"`))
+		Expect(string(lines[2])).To(MatchRegexp(`^[^,]+,icmp,10.0.0.2,0,2,1,9999,,,$`))
+	})
+
+	It("should write the selected columns of the calls as TSV", func() {
+		output := &bytes.Buffer{}
+		options := moreping.CSVOptions{Separator: '\t', Calls: true, Columns: []string{"target", "port", "latency_ms", "details.port_state", "message"}}
+		sink, err := moreping.NewCSVSink(output, options)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(sink.WriteBatch(moreping.ProbeBatch{Probe: "tcp"})).To(Succeed())
+		Expect(sink.WriteResult(moreping.ProbeResult{Probe: "tcp", IpAddress: "10.0.0.1", Port: 22, Success: true, Latency: 1500 * time.Microsecond, Details: map[string]string{"port_state": "open"}})).To(Succeed())
+		Expect(moreping.NewLegacySink(sink).WriteTcpCall(moreping.TcpCall{IpAddress: "10.0.0.1", TcpPort: 23, Message: "connection refused", Latency: moreping.InfiniteLatency})).To(Succeed())
+
+		Expect(output.String()).To(Equal("target\tport\tlatency_ms\tdetails.port_state\tmessage\n" +
+			"10.0.0.1\t22\t1.5\topen\t\n" +
+			"10.0.0.1\t23\t\t\tconnection refused\n"))
+	})
+
+	It("should refuse the unknown columns", func() {
+		_, err := moreping.NewCSVSink(&bytes.Buffer{}, moreping.CSVOptions{Columns: []string{"target", "jitter"}})
+		Expect(err).To(HaveOccurred())
+		_, err = moreping.NewCSVSink(&bytes.Buffer{}, moreping.CSVOptions{Columns: []string{"details.port_state"}})
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,333 @@
+package moreping
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// The ANSI escape sequences of the dashboard.
+const (
+	ansiHome      = "\033[H"
+	ansiClearLine = "\033[K"
+	ansiClearDown = "\033[J"
+	ansiReset     = "\033[0m"
+	ansiBold      = "\033[1m"
+	ansiReverse   = "\033[7m"
+	ansiRed       = "\033[31m"
+	ansiGreen     = "\033[32m"
+	ansiYellow    = "\033[33m"
+)
+
+// The sort orders of the dashboard, in the order the `s` key cycles through them.
+var dashboardSorts = []string{"target", "status", "loss", "latency"}
+
+// sparkBars are the levels of the sparklines, sparkLost marks the batches without successful calls.
+var sparkBars = []rune("▁▂▃▄▅▆▇█")
+
+const sparkLost = '×'
+
+// dashboardRow is the state of a target on the dashboard.
+type dashboardRow struct {
+	key         seriesKey
+	last        ProbeBatch
+	medians     []time.Duration // the medians of the last batches, -1 for the batches without successful calls
+	lastFailure string
+	failedAt    time.Time
+}
+
+// Dashboard is a result sink keeping the state of every target, to be rendered as a full-screen
+// terminal UI (with ANSI escape sequences) listing the targets with their status, loss, latency
+// sparkline and last failure, sorted and filtered with the keys passed to HandleKey.
+type Dashboard struct {
+	mutex     sync.Mutex
+	rows      map[seriesKey]*dashboardRow
+	sparkLen  int
+	sortBy    int
+	reverse   bool
+	filter    string
+	filtering bool
+}
+
+// NewDashboard creates a dashboard drawing the sparklines of the last sparkLen batches (30 by default).
+func NewDashboard(sparkLen int) *Dashboard {
+	if sparkLen <= 0 {
+		sparkLen = 30
+	}
+	return &Dashboard{rows: map[seriesKey]*dashboardRow{}, sparkLen: sparkLen}
+}
+
+func (d *Dashboard) rowFor(probe string, target string, port int) *dashboardRow {
+	key := seriesKey{probe: probe, target: target, port: port}
+	row, ok := d.rows[key]
+	if !ok {
+		row = &dashboardRow{key: key}
+		d.rows[key] = row
+	}
+	return row
+}
+
+// WriteResult keeps the last failure of the target.
+func (d *Dashboard) WriteResult(result ProbeResult) error {
+	if result.Success {
+		return nil
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	row := d.rowFor(result.Probe, result.IpAddress, result.Port)
+	row.lastFailure, row.failedAt = result.Message, result.Timestamp
+	return nil
+}
+
+// WriteBatch updates the status, the loss and the sparkline of the target.
+func (d *Dashboard) WriteBatch(batch ProbeBatch) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	row := d.rowFor(batch.Probe, batch.IpAddress, batch.Port)
+	row.last = batch
+	median := time.Duration(-1)
+	if len(batch.Latencies) > 0 {
+		median = medianLatency(batch)
+	}
+	row.medians = append(row.medians, median)
+	if len(row.medians) > d.sparkLen {
+		row.medians = row.medians[len(row.medians)-d.sparkLen:]
+	}
+	return nil
+}
+
+// HandleKey applies a key pressed on the dashboard, telling whether it is time to quit:
+// `s` cycles through the sort orders, `r` reverses the order, `/` starts typing a filter
+// (applied on Enter, cleared on Esc) and `q` or Ctrl-C quits.
+func (d *Dashboard) HandleKey(key byte) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.filtering {
+		switch {
+		case key == '\r' || key == '\n':
+			d.filtering = false
+		case key == 27: // Esc
+			d.filtering, d.filter = false, ""
+		case key == 127 || key == 8: // Backspace
+			if len(d.filter) > 0 {
+				d.filter = d.filter[:len(d.filter)-1]
+			}
+		case key == 3: // Ctrl-C
+			return true
+		case key >= ' ' && key < 127:
+			d.filter += string(key)
+		}
+		return false
+	}
+	switch key {
+	case 'q', 3:
+		return true
+	case 's':
+		d.sortBy = (d.sortBy + 1) % len(dashboardSorts)
+	case 'r':
+		d.reverse = !d.reverse
+	case '/':
+		d.filtering, d.filter = true, ""
+	case 27:
+		d.filter = ""
+	}
+	return false
+}
+
+// stateRank sorts the worst states first, and the targets without batches (yet) last.
+var stateRank = map[TargetState]int{StateDown: 0, StateDegraded: 1, StateUp: 2, "": 3}
+
+// sortedRows are the rows matching the filter, in the current sort order.
+func (d *Dashboard) sortedRows() []*dashboardRow {
+	rows := []*dashboardRow{}
+	for _, row := range d.rows {
+		if d.filter == "" || strings.Contains(strings.ToLower(row.searchText()), strings.ToLower(d.filter)) {
+			rows = append(rows, row)
+		}
+	}
+	byTarget := func(i, j int) bool {
+		if rows[i].key.target != rows[j].key.target {
+			return rows[i].key.target < rows[j].key.target
+		}
+		if rows[i].key.port != rows[j].key.port {
+			return rows[i].key.port < rows[j].key.port
+		}
+		return rows[i].key.probe < rows[j].key.probe
+	}
+	less := byTarget
+	switch dashboardSorts[d.sortBy] {
+	case "status":
+		less = func(i, j int) bool {
+			ri, rj := stateRank[rows[i].state()], stateRank[rows[j].state()]
+			if ri != rj {
+				return ri < rj
+			}
+			return byTarget(i, j)
+		}
+	case "loss":
+		less = func(i, j int) bool {
+			if rows[i].last.PctPcktLoss != rows[j].last.PctPcktLoss {
+				return rows[i].last.PctPcktLoss > rows[j].last.PctPcktLoss
+			}
+			return byTarget(i, j)
+		}
+	case "latency":
+		less = func(i, j int) bool {
+			mi, mj := rows[i].lastMedian(), rows[j].lastMedian()
+			if mi != mj {
+				return mi > mj
+			}
+			return byTarget(i, j)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if d.reverse {
+			return less(j, i)
+		}
+		return less(i, j)
+	})
+	return rows
+}
+
+// state is the state of the target from its last batch (empty before the first batch).
+func (r *dashboardRow) state() TargetState {
+	if len(r.medians) == 0 {
+		return ""
+	}
+	return StateOf(r.last)
+}
+
+func (r *dashboardRow) lastMedian() time.Duration {
+	if len(r.medians) == 0 {
+		return -1
+	}
+	return r.medians[len(r.medians)-1]
+}
+
+func (r *dashboardRow) searchText() string {
+	return fmt.Sprintf("%s %s %d %s %s", r.key.probe, r.key.target, r.key.port, r.state(), r.lastFailure)
+}
+
+// sparkline draws the medians relatively to the slowest one.
+func (r *dashboardRow) sparkline(length int) string {
+	var slowest time.Duration
+	for _, median := range r.medians {
+		if median > slowest {
+			slowest = median
+		}
+	}
+	spark := []rune(strings.Repeat(" ", length-len(r.medians)))
+	for _, median := range r.medians {
+		switch {
+		case median < 0:
+			spark = append(spark, sparkLost)
+		case slowest == 0:
+			spark = append(spark, sparkBars[0])
+		default:
+			spark = append(spark, sparkBars[int(median*time.Duration(len(sparkBars)-1)/slowest)])
+		}
+	}
+	return string(spark)
+}
+
+// Render draws the dashboard on a terminal of the given size (in characters), from its top left corner.
+func (d *Dashboard) Render(w io.Writer, width int, height int) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	rows := d.sortedRows()
+	counts := map[TargetState]int{}
+	for _, row := range d.rows {
+		counts[row.state()]++
+	}
+
+	lines := []string{}
+	header := fmt.Sprintf("moreping dashboard  %s  %d targets: %d up, %d degraded, %d down",
+		time.Now().Format("2006-01-02 15:04:05"), len(d.rows), counts[StateUp], counts[StateDegraded], counts[StateDown])
+	lines = append(lines, ansiBold+truncate(header, width)+ansiReset)
+	order := dashboardSorts[d.sortBy]
+	if d.reverse {
+		order += " (reversed)"
+	}
+	status := "sort: " + order
+	if d.filtering {
+		status += "  filter: " + d.filter + "_"
+	} else if d.filter != "" {
+		status += "  filter: " + d.filter
+	}
+	lines = append(lines, truncate(status, width), "")
+
+	columns := fmt.Sprintf("%-9s %-6s %-28s %6s %9s %9s  %-*s  %s", "STATUS", "PROBE", "TARGET", "LOSS%", "MEDIAN", "MAX", d.sparkLen, "MEDIANS", "LAST FAILURE")
+	lines = append(lines, ansiReverse+truncate(padRight(columns, width), width)+ansiReset)
+
+	footer := "s: sort  r: reverse  /: filter (Enter to apply, Esc to clear)  q: quit"
+	visible := height - len(lines) - 2
+	for idx, row := range rows {
+		if idx >= visible {
+			lines = append(lines, truncate(fmt.Sprintf("... %d more targets", len(rows)-idx), width))
+			break
+		}
+		lines = append(lines, d.renderRow(row, width))
+	}
+	if len(rows) == 0 {
+		lines = append(lines, "no targets yet")
+	}
+	for len(lines) < height-1 {
+		lines = append(lines, "")
+	}
+	lines = append(lines, truncate(footer, width))
+
+	_, err := io.WriteString(w, ansiHome+strings.Join(lines, ansiClearLine+"\r\n")+ansiClearLine+ansiClearDown)
+	return err
+}
+
+func (d *Dashboard) renderRow(row *dashboardRow, width int) string {
+	state := row.state()
+	color := map[TargetState]string{StateUp: ansiGreen, StateDegraded: ansiYellow, StateDown: ansiRed}[state]
+	if state == "" {
+		state = "pending"
+	}
+	target := row.key.target
+	if row.key.port != 0 {
+		target = fmt.Sprintf("%s:%d", row.key.target, row.key.port)
+	}
+	loss, median, max := "-", "-", "-"
+	if len(row.medians) > 0 {
+		loss = fmt.Sprintf("%.1f", row.last.PctPcktLoss*100)
+		if len(row.last.Latencies) > 0 {
+			median = fmt.Sprintf("%.2fms", milliseconds(row.lastMedian()))
+			max = fmt.Sprintf("%.2fms", milliseconds(row.last.MaxLatency))
+		}
+	}
+	failure := "-"
+	if row.lastFailure != "" {
+		failure = fmt.Sprintf("%s %s", row.failedAt.Format("15:04:05"), failureReason(row.lastFailure))
+	}
+	rest := fmt.Sprintf(" %-6s %-28s %6s %9s %9s  %s  %s", row.key.probe, target, loss, median, max, row.sparkline(d.sparkLen), failure)
+	statusCell := fmt.Sprintf("%-9s", state)
+	if width <= len(statusCell) {
+		return color + truncate(statusCell, width) + ansiReset
+	}
+	return color + statusCell + ansiReset + truncate(rest, width-len(statusCell))
+}
+
+// truncate cuts a line (without escape sequences) to a number of characters.
+func truncate(line string, width int) string {
+	if utf8.RuneCountInString(line) <= width {
+		return line
+	}
+	if width <= 0 {
+		return ""
+	}
+	return string([]rune(line)[:width])
+}
+
+func padRight(line string, width int) string {
+	if count := utf8.RuneCountInString(line); count < width {
+		return line + strings.Repeat(" ", width-count)
+	}
+	return line
+}
@@ -0,0 +1,100 @@
+package moreping_test
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/tappoz/moreping/src/moreping"
+)
+
+var _ = Describe("Dashboard", func() {
+
+	ansi := regexp.MustCompile("\033\\[[0-9;]*[A-Za-z]")
+	var dashboard *moreping.Dashboard
+
+	// render returns the lines of the targets, without the escape sequences
+	render := func(width int, height int) []string {
+		output := &bytes.Buffer{}
+		Expect(dashboard.Render(output, width, height)).To(Succeed())
+		lines := strings.Split(ansi.ReplaceAllString(output.String(), ""), "\r\n")
+		Expect(lines).To(HaveLen(height))
+		for _, line := range lines {
+			Expect(utf8.RuneCountInString(line)).To(BeNumerically("<=", width))
+		}
+		return lines
+	}
+
+	targetLines := func(lines []string) []string {
+		targets := []string{}
+		for _, line := range lines {
+			if strings.HasPrefix(line, "up") || strings.HasPrefix(line, "degraded") || strings.HasPrefix(line, "down") {
+				targets = append(targets, line)
+			}
+		}
+		return targets
+	}
+
+	BeforeEach(func() {
+		dashboard = moreping.NewDashboard(5)
+		now := time.Now()
+		for round := 0; round < 3; round++ {
+			dashboard.WriteBatch(moreping.ProbeBatch{Probe: "tcp", IpAddress: "10.0.0.1", Port: 22, Timestamp: now,
+				Latencies: []time.Duration{time.Duration(round+1) * time.Millisecond}, MaxLatency: time.Duration(round+1) * time.Millisecond})
+		}
+		dashboard.WriteResult(moreping.ProbeResult{Probe: "tcp", IpAddress: "10.0.0.2", Port: 22, Timestamp: now, Message: "connection refused"})
+		dashboard.WriteBatch(moreping.ProbeBatch{Probe: "tcp", IpAddress: "10.0.0.2", Port: 22, Timestamp: now, PctPcktLoss: 1})
+		dashboard.WriteBatch(moreping.ProbeBatch{Probe: "icmp", IpAddress: "10.0.0.3", Timestamp: now, PctPcktLoss: 0.5,
+			Latencies: []time.Duration{9 * time.Millisecond}, MaxLatency: 9 * time.Millisecond})
+	})
+
+	It("should list the targets with their status, loss, latencies and last failure", func() {
+		lines := render(140, 12)
+		Expect(lines[0]).To(ContainSubstring("3 targets: 1 up, 1 degraded, 1 down"))
+		Expect(lines[1]).To(Equal("sort: target"))
+		targets := targetLines(lines)
+		Expect(targets).To(HaveLen(3))
+		Expect(targets[0]).To(MatchRegexp(`^up +tcp +10\.0\.0\.1:22 +0\.0 +3\.00ms +3\.00ms +  ▃▅█  -$`))
+		Expect(targets[1]).To(MatchRegexp(`^down +tcp +10\.0\.0\.2:22 +100\.0 +- +- +    ×  \d\d:\d\d:\d\d connection refused$`))
+		Expect(targets[2]).To(MatchRegexp(`^degraded +icmp +10\.0\.0\.3 +50\.0 +9\.00ms`))
+		Expect(lines[11]).To(HavePrefix("s: sort"))
+	})
+
+	It("should sort and filter the targets with the keys", func() {
+		Expect(dashboard.HandleKey('s')).To(BeFalse())
+		targets := targetLines(render(140, 12))
+		Expect(targets[0]).To(HavePrefix("down"))
+		Expect(targets[2]).To(HavePrefix("up"))
+
+		dashboard.HandleKey('r')
+		targets = targetLines(render(140, 12))
+		Expect(targets[0]).To(HavePrefix("up"))
+
+		for _, key := range []byte("/refusedx") {
+			dashboard.HandleKey(key)
+		}
+		dashboard.HandleKey(127)
+		Expect(dashboard.HandleKey('q')).To(BeFalse()) // typed in the filter
+		dashboard.HandleKey(127)
+		dashboard.HandleKey('\r')
+		lines := render(140, 12)
+		Expect(lines[1]).To(Equal("sort: status (reversed)  filter: refused"))
+		targets = targetLines(lines)
+		Expect(targets).To(HaveLen(1))
+		Expect(targets[0]).To(ContainSubstring("10.0.0.2"))
+
+		dashboard.HandleKey(27)
+		Expect(targetLines(render(140, 12))).To(HaveLen(3))
+		Expect(dashboard.HandleKey('q')).To(BeTrue())
+	})
+
+	It("should fit a small terminal", func() {
+		lines := render(30, 7)
+		Expect(targetLines(lines)).To(HaveLen(1))
+		Expect(lines[5]).To(Equal("... 2 more targets"))
+	})
+})
@@ -0,0 +1,306 @@
+package moreping
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// The default templates of the emails: the first line is the subject, the rest is the body.
+const (
+	DefaultAlertTemplate = `[moreping] {{.Name}} is {{.State}}
+
+{{.Name}} is {{.State}} since {{.Last.Timestamp.Format "2006-01-02 15:04:05 MST"}}.
+{{template "changes" .}}`
+
+	DefaultRecoveryTemplate = `[moreping] {{.Name}} recovered
+
+{{.Name}} is up again since {{.Last.Timestamp.Format "2006-01-02 15:04:05 MST"}}{{with .Last.Since}} ({{$.Last.PreviousState}} since {{.Format "2006-01-02 15:04:05 MST"}}){{end}}.
+{{template "changes" .}}`
+
+	emailChangesTemplate = `{{define "changes"}}
+State changes:
+{{range .Changes}}  {{.Timestamp.Format "2006-01-02 15:04:05"}}  {{or .PreviousState "unknown"}} -> {{.State}}  loss {{printf "%.0f" (percent .Batch.LossRatio)}}%  avg {{printf "%.2f" .Batch.AvgLatencyMs}} ms
+{{end}}{{with .Last.Failures}}
+Failures of the recent batches:
+{{range $reason, $count := .}}  {{$count}} x {{$reason}}
+{{end}}{{end}}{{end}}`
+)
+
+// EmailOptions configures the email notifications. The zero values are replaced by the defaults.
+type EmailOptions struct {
+	Address            string        // the host:port of the SMTP server
+	From               string        // the sender address
+	To                 []string      // the recipient addresses
+	Username           string        // PLAIN authentication when set (only over TLS, or to localhost)
+	Password           string        // the password of the authentication
+	RequireTLS         bool          // fail when the server does not offer STARTTLS (otherwise used when offered)
+	InsecureSkipVerify bool          // do not verify the certificate of the server (e.g. self-signed)
+	AlertTemplate      string        // the text/template of the alerts, DefaultAlertTemplate by default
+	RecoveryTemplate   string        // the text/template of the recoveries, DefaultRecoveryTemplate by default
+	RateLimit          time.Duration // the minimum time between two emails of a target (default 15m)
+	Timeout            time.Duration // the timeout of the whole SMTP exchange (default 30s)
+	Recent             int           // the recent batches of the failure reasons (default 5)
+}
+
+// EmailNotification is the data of the email templates: the state changes of a target
+// grouped within the rate limit, the last one being the current state of the target.
+type EmailNotification struct {
+	Probe    string
+	Target   string
+	Port     int
+	Name     string // the target (with the port) and the probe type, e.g. "10.0.0.1:22 (tcp)"
+	State    string // the current state
+	Recovery bool   // whether the target is up again
+	Changes  []JSONStateChange
+}
+
+// Last is the last state change of the target.
+func (n EmailNotification) Last() JSONStateChange {
+	return n.Changes[len(n.Changes)-1]
+}
+
+// emailTarget is the rate limiting state of a target.
+type emailTarget struct {
+	lastSent time.Time
+	pending  []StateChange
+	timer    *time.Timer
+}
+
+// EmailSink is a result sink sending an alert email when a target becomes degraded or down,
+// and a recovery email when it is up again. At most an email per target is sent every rate
+// limit period: the state changes within it are grouped into the next email (an alert, or a
+// recovery if the target is up by then). The emails are sent in the background.
+type EmailSink struct {
+	mutex     sync.Mutex
+	options   EmailOptions
+	templates map[bool]*template.Template // by recovery
+	tracker   *StateTracker
+	targets   map[seriesKey]*emailTarget
+	queue     chan EmailNotification
+	done      chan struct{}
+	closed    bool
+}
+
+// NewEmailSink creates a sink sending the emails through the SMTP server,
+// failing on invalid options or templates. Close it to send the pending emails.
+func NewEmailSink(options EmailOptions) (*EmailSink, error) {
+	if options.Address == "" || options.From == "" || len(options.To) == 0 {
+		return nil, errors.New("the SMTP server, the sender and the recipients are required")
+	}
+	if _, _, err := net.SplitHostPort(options.Address); err != nil {
+		return nil, err
+	}
+	if options.AlertTemplate == "" {
+		options.AlertTemplate = DefaultAlertTemplate
+	}
+	if options.RecoveryTemplate == "" {
+		options.RecoveryTemplate = DefaultRecoveryTemplate
+	}
+	if options.RateLimit <= 0 {
+		options.RateLimit = 15 * time.Minute
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = 30 * time.Second
+	}
+	sink := &EmailSink{
+		options:   options,
+		templates: map[bool]*template.Template{},
+		tracker:   NewStateTracker(options.Recent),
+		targets:   map[seriesKey]*emailTarget{},
+		queue:     make(chan EmailNotification, 100),
+		done:      make(chan struct{}),
+	}
+	for recovery, text := range map[bool]string{false: options.AlertTemplate, true: options.RecoveryTemplate} {
+		tmpl, err := template.New("email").Funcs(notificationFuncs).Parse(emailChangesTemplate)
+		if err == nil {
+			tmpl, err = tmpl.Parse(text)
+		}
+		if err != nil {
+			return nil, err
+		}
+		sink.templates[recovery] = tmpl
+	}
+	go sink.run()
+	return sink, nil
+}
+
+// WriteResult ignores the single calls: the states come from the batches.
+func (e *EmailSink) WriteResult(result ProbeResult) error {
+	return nil
+}
+
+// WriteBatch sends an email when the state of the target changed, now or at the end of
+// the rate limit period of the target.
+func (e *EmailSink) WriteBatch(batch ProbeBatch) error {
+	change, changed := e.tracker.Update(batch)
+	if !changed {
+		return nil
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.closed {
+		return nil
+	}
+	key := seriesKey{probe: change.Probe, target: change.IpAddress, port: change.Port}
+	target, ok := e.targets[key]
+	if !ok {
+		target = &emailTarget{}
+		e.targets[key] = target
+	}
+	target.pending = append(target.pending, change)
+	if target.timer != nil {
+		return nil
+	}
+	if wait := target.lastSent.Add(e.options.RateLimit).Sub(time.Now()); wait > 0 {
+		target.timer = time.AfterFunc(wait, func() {
+			e.mutex.Lock()
+			defer e.mutex.Unlock()
+			if !e.closed {
+				e.flush(target)
+			}
+		})
+		return nil
+	}
+	e.flush(target)
+	return nil
+}
+
+// flush queues the email of the pending changes of a target (with the mutex held).
+func (e *EmailSink) flush(target *emailTarget) {
+	changes := target.pending
+	target.pending, target.timer, target.lastSent = nil, nil, time.Now()
+	last := changes[len(changes)-1]
+	notification := EmailNotification{
+		Probe:    last.Probe,
+		Target:   last.IpAddress,
+		Port:     last.Port,
+		Name:     fmt.Sprintf("%s (%s)", last.IpAddress, last.Probe),
+		State:    string(last.State),
+		Recovery: last.State == StateUp,
+	}
+	if last.Port != 0 {
+		notification.Name = fmt.Sprintf("%s:%d (%s)", last.IpAddress, last.Port, last.Probe)
+	}
+	for _, change := range changes {
+		notification.Changes = append(notification.Changes, NewJSONStateChange(change))
+	}
+	select {
+	case e.queue <- notification:
+	default:
+		Logger.Printf("Too many pending emails, dropped the %s email of %s\n", notification.State, notification.Name)
+	}
+}
+
+// Close sends the emails of the changes waiting for the rate limit, then the pending ones, and stops the sink.
+func (e *EmailSink) Close() error {
+	e.mutex.Lock()
+	if !e.closed {
+		e.closed = true
+		for _, target := range e.targets {
+			if target.timer != nil {
+				target.timer.Stop()
+			}
+			if len(target.pending) > 0 {
+				e.flush(target)
+			}
+		}
+		close(e.queue)
+	}
+	e.mutex.Unlock()
+	<-e.done
+	return nil
+}
+
+func (e *EmailSink) run() {
+	defer close(e.done)
+	for notification := range e.queue {
+		if err := e.send(notification); err != nil {
+			Logger.Printf("Unable to send the %s email of %s: %s\n", notification.State, notification.Name, err)
+		}
+	}
+}
+
+// Message renders the email of a notification (the headers and the body, with CRLF line endings).
+func (e *EmailSink) Message(notification EmailNotification) ([]byte, error) {
+	text := &bytes.Buffer{}
+	if err := e.templates[notification.Recovery].Execute(text, notification); err != nil {
+		return nil, err
+	}
+	subject, body := text.String(), ""
+	if newline := strings.Index(subject, "\n"); newline >= 0 {
+		subject, body = subject[:newline], strings.TrimLeft(subject[newline+1:], "\n")
+	}
+
+	message := &bytes.Buffer{}
+	fmt.Fprintf(message, "From: %s\r\n", e.options.From)
+	fmt.Fprintf(message, "To: %s\r\n", strings.Join(e.options.To, ", "))
+	fmt.Fprintf(message, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", strings.TrimSpace(subject)))
+	fmt.Fprintf(message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	message.WriteString("MIME-Version: 1.0\r\n")
+	message.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	message.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	message.WriteString(strings.Replace(strings.Replace(body, "\r\n", "\n", -1), "\n", "\r\n", -1))
+	return message.Bytes(), nil
+}
+
+// send delivers the email of a notification: STARTTLS when offered (or required),
+// then the authentication when configured.
+func (e *EmailSink) send(notification EmailNotification) error {
+	message, err := e.Message(notification)
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialTimeout("tcp", e.options.Address, e.options.Timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(e.options.Timeout))
+	host, _, _ := net.SplitHostPort(e.options.Address)
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host, InsecureSkipVerify: e.options.InsecureSkipVerify}); err != nil {
+			return err
+		}
+	} else if e.options.RequireTLS {
+		return errors.New("the SMTP server does not offer STARTTLS")
+	}
+	if e.options.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", e.options.Username, e.options.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(e.options.From); err != nil {
+		return err
+	}
+	for _, to := range e.options.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	data, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := data.Write(message); err != nil {
+		return err
+	}
+	if err := data.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
@@ -0,0 +1,234 @@
+package moreping_test
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/tappoz/moreping/src/moreping"
+)
+
+// smtpMessage is an email received by the SMTP stand-in
+type smtpMessage struct {
+	tls  bool
+	auth string
+	from string
+	to   []string
+	data string
+}
+
+// smtpStandIn is a minimal SMTP server, offering STARTTLS with a certificate when set
+type smtpStandIn struct {
+	listener net.Listener
+	cert     *tls.Certificate
+	mutex    sync.Mutex
+	messages []smtpMessage
+}
+
+func newSMTPStandIn(cert *tls.Certificate) *smtpStandIn {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).NotTo(HaveOccurred())
+	s := &smtpStandIn{listener: listener, cert: cert}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpStandIn) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { fmt.Fprintf(conn, "%s\r\n", line) }
+	message := smtpMessage{}
+	reply("220 stand-in ESMTP")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch {
+		case command == "EHLO":
+			reply("250-stand-in")
+			if s.cert != nil && !message.tls {
+				reply("250-STARTTLS")
+			}
+			reply("250 AUTH PLAIN")
+		case command == "STARTTLS":
+			reply("220 ready")
+			tlsConn := tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{*s.cert}})
+			if tlsConn.Handshake() != nil {
+				return
+			}
+			conn, reader, message.tls = tlsConn, bufio.NewReader(tlsConn), true
+		case command == "AUTH":
+			credentials, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(line, "AUTH PLAIN "))
+			message.auth = string(credentials)
+			reply("235 authenticated")
+		case command == "MAIL":
+			message.from = strings.TrimPrefix(line, "MAIL FROM:")
+			reply("250 ok")
+		case command == "RCPT":
+			message.to = append(message.to, strings.TrimPrefix(line, "RCPT TO:"))
+			reply("250 ok")
+		case command == "DATA":
+			reply("354 go ahead")
+			data := []string{}
+			for {
+				dataLine, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if dataLine == ".\r\n" {
+					break
+				}
+				data = append(data, dataLine)
+			}
+			message.data = strings.Join(data, "")
+			s.mutex.Lock()
+			s.messages = append(s.messages, message)
+			s.mutex.Unlock()
+			reply("250 queued")
+		case command == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func (s *smtpStandIn) received() []smtpMessage {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]smtpMessage{}, s.messages...)
+}
+
+var _ = Describe("Emails", func() {
+
+	It("should send the alerts and the recoveries over STARTTLS with authentication", func() {
+		// the self-signed certificate of the test HTTPS servers
+		httpsServer := httptest.NewTLSServer(nil)
+		cert := httpsServer.TLS.Certificates[0]
+		httpsServer.Close()
+		standIn := newSMTPStandIn(&cert)
+		defer standIn.listener.Close()
+
+		sink, err := moreping.NewEmailSink(moreping.EmailOptions{
+			Address: standIn.listener.Addr().String(), From: "moreping@example.com", To: []string{"ops@example.com", "noc@example.com"},
+			Username: "moreping", Password: "secret", RequireTLS: true, InsecureSkipVerify: true, RateLimit: time.Millisecond,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sink.WriteBatch(stateBatch(0, 0))).To(Succeed())
+		Expect(sink.WriteBatch(stateBatch(1, 4))).To(Succeed())
+		Eventually(standIn.received).Should(HaveLen(1))
+		time.Sleep(5 * time.Millisecond)
+		Expect(sink.WriteBatch(stateBatch(2, 0))).To(Succeed())
+		Eventually(standIn.received).Should(HaveLen(2))
+		Expect(sink.Close()).To(Succeed())
+
+		alert := standIn.received()[0]
+		Expect(alert.tls).To(BeTrue())
+		Expect(alert.auth).To(Equal("\x00moreping\x00secret"))
+		Expect(alert.from).To(Equal("<moreping@example.com>"))
+		Expect(alert.to).To(Equal([]string{"<ops@example.com>", "<noc@example.com>"}))
+		Expect(alert.data).To(ContainSubstring("To: ops@example.com, noc@example.com\r\n"))
+		Expect(alert.data).To(ContainSubstring("Subject: [moreping] 10.0.0.1:22 (tcp) is down\r\n"))
+		Expect(alert.data).To(ContainSubstring("\r\n\r\n10.0.0.1:22 (tcp) is down since 2017-07-14 10:01:00 UTC.\r\n"))
+		Expect(alert.data).To(ContainSubstring("  2017-07-14 10:01:00  up -> down  loss 100%  avg 2.00 ms\r\n"))
+		Expect(alert.data).To(ContainSubstring("Failures of the recent batches:\r\n  4 x i/o timeout\r\n"))
+
+		recovery := standIn.received()[1].data
+		Expect(recovery).To(ContainSubstring("Subject: [moreping] 10.0.0.1:22 (tcp) recovered\r\n"))
+		Expect(recovery).To(ContainSubstring("is up again since 2017-07-14 10:02:00 UTC (down since 2017-07-14 10:01:00 UTC).\r\n"))
+	})
+
+	It("should group the state changes of a target within the rate limit", func() {
+		standIn := newSMTPStandIn(nil)
+		defer standIn.listener.Close()
+		sink, err := moreping.NewEmailSink(moreping.EmailOptions{
+			Address: standIn.listener.Addr().String(), From: "moreping@example.com", To: []string{"ops@example.com"},
+			RateLimit: 200 * time.Millisecond,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		for minute, failures := range []int{4, 0, 1, 4, 0} {
+			Expect(sink.WriteBatch(stateBatch(minute, failures))).To(Succeed())
+		}
+		other := stateBatch(5, 4)
+		other.IpAddress = "10.0.0.2"
+		Expect(sink.WriteBatch(other)).To(Succeed())
+
+		Eventually(standIn.received).Should(HaveLen(2))
+		Consistently(standIn.received, 100*time.Millisecond).Should(HaveLen(2))
+		Eventually(standIn.received).Should(HaveLen(3))
+		Expect(sink.Close()).To(Succeed())
+
+		messages := standIn.received()
+		Expect(messages[0].tls).To(BeFalse())
+		Expect(messages[0].auth).To(BeEmpty())
+		subjects := []string{}
+		for _, message := range messages {
+			subject := strings.SplitN(strings.SplitN(message.data, "Subject: ", 2)[1], "\r\n", 2)[0]
+			subjects = append(subjects, subject)
+		}
+		Expect(subjects).To(ConsistOf(
+			"[moreping] 10.0.0.1:22 (tcp) is down",
+			"[moreping] 10.0.0.2:22 (tcp) is down",
+			"[moreping] 10.0.0.1:22 (tcp) recovered",
+		))
+		grouped := messages[2].data
+		Expect(strings.Count(grouped, " -> ")).To(Equal(4))
+		Expect(grouped).To(ContainSubstring("down -> up"))
+		Expect(grouped).To(ContainSubstring("up -> degraded"))
+	})
+
+	It("should send the emails waiting for the rate limit when closed", func() {
+		standIn := newSMTPStandIn(nil)
+		defer standIn.listener.Close()
+		sink, _ := moreping.NewEmailSink(moreping.EmailOptions{
+			Address: standIn.listener.Addr().String(), From: "moreping@example.com", To: []string{"ops@example.com"},
+			AlertTemplate: "{{.Target}} {{.State}}\n\n{{len .Changes}} changes", RecoveryTemplate: "{{.Target}} recovered\n",
+		})
+		for minute, failures := range []int{4, 1} {
+			Expect(sink.WriteBatch(stateBatch(minute, failures))).To(Succeed())
+		}
+		Expect(sink.Close()).To(Succeed())
+		messages := standIn.received()
+		Expect(messages).To(HaveLen(2))
+		Expect(messages[1].data).To(ContainSubstring("Subject: 10.0.0.1 degraded\r\n"))
+		Expect(messages[1].data).To(HaveSuffix("\r\n\r\n1 changes\r\n"))
+	})
+
+	It("should refuse the STARTTLS and invalid options", func() {
+		standIn := newSMTPStandIn(nil)
+		defer standIn.listener.Close()
+		sink, _ := moreping.NewEmailSink(moreping.EmailOptions{
+			Address: standIn.listener.Addr().String(), From: "moreping@example.com", To: []string{"ops@example.com"}, RequireTLS: true,
+		})
+		Expect(sink.WriteBatch(stateBatch(0, 4))).To(Succeed())
+		Expect(sink.Close()).To(Succeed())
+		Expect(standIn.received()).To(BeEmpty())
+
+		_, err := moreping.NewEmailSink(moreping.EmailOptions{Address: "localhost", From: "a@example.com", To: []string{"b@example.com"}})
+		Expect(err).To(HaveOccurred())
+		_, err = moreping.NewEmailSink(moreping.EmailOptions{Address: "localhost:25", From: "a@example.com"})
+		Expect(err).To(HaveOccurred())
+		_, err = moreping.NewEmailSink(moreping.EmailOptions{Address: "localhost:25", From: "a@example.com", To: []string{"b@example.com"}, AlertTemplate: "{{.Nope"})
+		Expect(err).To(HaveOccurred())
+	})
+})
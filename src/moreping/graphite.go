@@ -0,0 +1,265 @@
+package moreping
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMetricTemplate is the default template of the StatsD and Graphite metric names.
+const DefaultMetricTemplate = "moreping.{probe}.{target}.{port}"
+
+// maxStatsDPacket keeps the StatsD datagrams within the usual MTU of the networks.
+const maxStatsDPacket = 1432
+
+// metricNameEscaper replaces the characters which have a meaning in the metric names,
+// e.g. the dots of the IP addresses which would be taken as separators by Graphite.
+var metricNameEscaper = strings.NewReplacer(".", "_", ":", "_", " ", "_", "/", "_")
+
+// MetricName builds the metric name of a batch from a template with the {probe},
+// {target} and {port} placeholders, e.g. "moreping.{probe}.{target}.{port}"
+// becomes "moreping.tcp.10_0_0_1.22".
+func MetricName(template string, batch ProbeBatch) string {
+	return strings.NewReplacer(
+		"{probe}", metricNameEscaper.Replace(batch.Probe),
+		"{target}", metricNameEscaper.Replace(batch.IpAddress),
+		"{port}", strconv.Itoa(batch.Port),
+	).Replace(template)
+}
+
+func metricTemplate(template string) string {
+	if template == "" {
+		return DefaultMetricTemplate
+	}
+	return template
+}
+
+// StatsDSink is a result sink sending the loss and the latency of every batch to a StatsD
+// server over UDP.
+type StatsDSink struct {
+	mutex    sync.Mutex
+	conn     net.Conn
+	template string
+}
+
+// NewStatsDSink creates a new StatsD sink, sending the `loss_ratio` and `avg_latency_ms` gauges
+// and a `latency` timing for every successful call (so that the server calculates the percentiles).
+func NewStatsDSink(address string, template string) (*StatsDSink, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	return &StatsDSink{conn: conn, template: metricTemplate(template)}, nil
+}
+
+// WriteResult ignores the single calls, the latencies are sent with the batch.
+func (s *StatsDSink) WriteResult(result ProbeResult) error {
+	return nil
+}
+
+// WriteBatch sends the metrics of the batch, as many as possible in each datagram.
+func (s *StatsDSink) WriteBatch(batch ProbeBatch) error {
+	name := MetricName(s.template, batch)
+	metrics := []string{
+		fmt.Sprintf("%s.loss_ratio:%s|g", name, strconv.FormatFloat(float64(batch.PctPcktLoss), 'f', -1, 32)),
+		fmt.Sprintf("%s.avg_latency_ms:%s|g", name, formatMilliseconds(batch.AvgLatency)),
+	}
+	for _, latency := range batch.Latencies {
+		metrics = append(metrics, fmt.Sprintf("%s.latency:%s|ms", name, formatMilliseconds(latency)))
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var packet bytes.Buffer
+	for _, metric := range metrics {
+		if packet.Len() > 0 && packet.Len()+1+len(metric) > maxStatsDPacket {
+			if _, err := s.conn.Write(packet.Bytes()); err != nil {
+				return err
+			}
+			packet.Reset()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(metric)
+	}
+	_, err := s.conn.Write(packet.Bytes())
+	return err
+}
+
+// Close closes the UDP socket.
+func (s *StatsDSink) Close() error {
+	return s.conn.Close()
+}
+
+// GraphiteOptions holds the configuration of the Graphite sink.
+// The zero values are replaced by the defaults.
+type GraphiteOptions struct {
+	Template      string        // the template of the metric names (default DefaultMetricTemplate)
+	RetryInterval time.Duration // the time between the writes while the server is down (default 10s)
+	MaxBuffer     int           // the lines kept while the server is down, the oldest are dropped (default 10000)
+	Timeout       time.Duration // the timeout of the connections and the writes (default 5s)
+}
+
+// GraphiteSink is a result sink sending the loss and the latency of every batch to a Graphite
+// server with the plaintext protocol over TCP. The lines are sent in the background (so that a
+// slow server does not hold up the probes), and kept and sent again when the server is down.
+type GraphiteSink struct {
+	mutex   sync.Mutex
+	address string
+	options GraphiteOptions
+	conn    net.Conn
+	lines   [][]byte
+	dropped int
+	pending chan struct{}
+	quit    chan struct{}
+	done    chan struct{}
+}
+
+// NewGraphiteSink creates a new Graphite sink, sending `loss_ratio`, `avg_latency_ms`, and
+// `min_latency_ms` / `max_latency_ms` when some calls were successful.
+// The connection is established at the first batch and again after a failure.
+// Close it to send the remaining lines.
+func NewGraphiteSink(address string, options GraphiteOptions) *GraphiteSink {
+	options.Template = metricTemplate(options.Template)
+	if options.RetryInterval <= 0 {
+		options.RetryInterval = 10 * time.Second
+	}
+	if options.MaxBuffer <= 0 {
+		options.MaxBuffer = 10000
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = 5 * time.Second
+	}
+	sink := &GraphiteSink{
+		address: address,
+		options: options,
+		pending: make(chan struct{}, 1),
+		quit:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go sink.run()
+	return sink
+}
+
+// WriteResult ignores the single calls.
+func (g *GraphiteSink) WriteResult(result ProbeResult) error {
+	return nil
+}
+
+// WriteBatch buffers the metrics of the batch, timestamped with the batch.
+func (g *GraphiteSink) WriteBatch(batch ProbeBatch) error {
+	name := MetricName(g.options.Template, batch)
+	timestamp := batch.Timestamp.Unix()
+	lines := [][]byte{
+		[]byte(fmt.Sprintf("%s.loss_ratio %s %d\n", name, strconv.FormatFloat(float64(batch.PctPcktLoss), 'f', -1, 32), timestamp)),
+		[]byte(fmt.Sprintf("%s.avg_latency_ms %s %d\n", name, formatMilliseconds(batch.AvgLatency), timestamp)),
+	}
+	if len(batch.Latencies) > 0 {
+		lines = append(lines,
+			[]byte(fmt.Sprintf("%s.min_latency_ms %s %d\n", name, formatMilliseconds(batch.MinLatency), timestamp)),
+			[]byte(fmt.Sprintf("%s.max_latency_ms %s %d\n", name, formatMilliseconds(batch.MaxLatency), timestamp)))
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.lines = append(g.lines, lines...)
+	g.trim()
+	select {
+	case g.pending <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Close sends the remaining lines (once), stops the sink and closes the TCP connection.
+func (g *GraphiteSink) Close() error {
+	close(g.quit)
+	<-g.done
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.conn != nil {
+		g.conn.Close()
+		g.conn = nil
+	}
+	if len(g.lines) > 0 {
+		return fmt.Errorf("unable to send %d lines to %s", len(g.lines), g.address)
+	}
+	return nil
+}
+
+// trim drops the oldest lines beyond the maximum buffer size.
+func (g *GraphiteSink) trim() {
+	if excess := len(g.lines) - g.options.MaxBuffer; excess > 0 {
+		g.lines = g.lines[excess:]
+		g.dropped += excess
+	}
+}
+
+func (g *GraphiteSink) run() {
+	defer close(g.done)
+	ticker := time.NewTicker(g.options.RetryInterval)
+	defer ticker.Stop()
+	failing := false
+	for {
+		select {
+		case <-ticker.C:
+			if failing {
+				failing = !g.flush()
+			}
+		case <-g.pending:
+			// while the server is down only retry at every interval
+			if !failing {
+				failing = !g.flush()
+			}
+		case <-g.quit:
+			g.flush()
+			return
+		}
+	}
+}
+
+// flush sends the buffered lines, putting them back in front of the buffer
+// when the connection or the write fails (in which case it returns false).
+func (g *GraphiteSink) flush() bool {
+	g.mutex.Lock()
+	lines := g.lines
+	g.lines = nil
+	if g.dropped > 0 {
+		Logger.Printf("Dropped %d Graphite lines (buffer full)\n", g.dropped)
+		g.dropped = 0
+	}
+	conn := g.conn
+	g.mutex.Unlock()
+	if len(lines) == 0 {
+		return true
+	}
+
+	var err error
+	if conn == nil {
+		conn, err = net.DialTimeout("tcp", g.address, g.options.Timeout)
+	}
+	if err == nil {
+		conn.SetWriteDeadline(time.Now().Add(g.options.Timeout))
+		if _, err = conn.Write(bytes.Join(lines, nil)); err != nil {
+			// connect again at the next flush
+			conn.Close()
+			conn = nil
+		}
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.conn = conn
+	if err != nil {
+		Logger.Printf("Unable to send %d lines to Graphite: %s\n", len(lines), err)
+		g.lines = append(lines, g.lines...)
+		g.trim()
+		return false
+	}
+	return true
+}
@@ -0,0 +1,116 @@
+package moreping_test
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/tappoz/moreping/src/moreping"
+)
+
+var _ = Describe("StatsD and Graphite", func() {
+
+	batch := moreping.ProbeBatch{Probe: "tcp", IpAddress: "10.0.0.1", Port: 22, Timestamp: time.Unix(1500000000, 0),
+		Experiments: 3, PctPcktLoss: 0.25, AvgLatency: 2500 * time.Microsecond, MinLatency: time.Millisecond, MaxLatency: 4 * time.Millisecond,
+		Latencies: []time.Duration{time.Millisecond, 4 * time.Millisecond}}
+
+	It("should build the metric names from the templates", func() {
+		Expect(moreping.MetricName(moreping.DefaultMetricTemplate, batch)).To(Equal("moreping.tcp.10_0_0_1.22"))
+		Expect(moreping.MetricName("network.{target}.port_{port}", moreping.ProbeBatch{IpAddress: "fe80::1", Port: 443})).To(Equal("network.fe80__1.port_443"))
+	})
+
+	It("should send the gauges and the timings to StatsD", func() {
+		server, err := net.ListenPacket("udp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		defer server.Close()
+		sink, err := moreping.NewStatsDSink(server.LocalAddr().String(), "")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(sink.WriteBatch(batch)).To(Succeed())
+
+		packet := make([]byte, 2048)
+		server.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := server.ReadFrom(packet)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(strings.Split(string(packet[:n]), "\n")).To(Equal([]string{
+			"moreping.tcp.10_0_0_1.22.loss_ratio:0.25|g",
+			"moreping.tcp.10_0_0_1.22.avg_latency_ms:2.5|g",
+			"moreping.tcp.10_0_0_1.22.latency:1|ms",
+			"moreping.tcp.10_0_0_1.22.latency:4|ms",
+		}))
+	})
+
+	// graphiteServer collects the lines received by a Graphite stand-in
+	graphiteServer := func(listener net.Listener) chan string {
+		lines := make(chan string, 100)
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					lines <- scanner.Text()
+				}
+			}
+		}()
+		return lines
+	}
+
+	It("should send the plaintext lines to Graphite", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		defer listener.Close()
+		lines := graphiteServer(listener)
+		sink := moreping.NewGraphiteSink(listener.Addr().String(), moreping.GraphiteOptions{Template: "net.{probe}.{target}"})
+
+		Expect(sink.WriteBatch(batch)).To(Succeed())
+		Expect(sink.WriteBatch(moreping.ProbeBatch{Probe: "icmp", IpAddress: "10.0.0.2", Timestamp: time.Unix(1500000060, 0), PctPcktLoss: 1, AvgLatency: moreping.InfiniteLatency})).To(Succeed())
+
+		expected := []string{
+			"net.tcp.10_0_0_1.loss_ratio 0.25 1500000000",
+			"net.tcp.10_0_0_1.avg_latency_ms 2.5 1500000000",
+			"net.tcp.10_0_0_1.min_latency_ms 1 1500000000",
+			"net.tcp.10_0_0_1.max_latency_ms 4 1500000000",
+			"net.icmp.10_0_0_2.loss_ratio 1 1500000060",
+			"net.icmp.10_0_0_2.avg_latency_ms 9999 1500000060",
+		}
+		for _, line := range expected {
+			Eventually(lines).Should(Receive(Equal(line)))
+		}
+		Expect(sink.Close()).To(Succeed())
+	})
+
+	It("should keep the lines while Graphite is down and send them once it is back", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		address := listener.Addr().String()
+		listener.Close()
+		sink := moreping.NewGraphiteSink(address, moreping.GraphiteOptions{RetryInterval: 50 * time.Millisecond})
+
+		Expect(sink.WriteBatch(batch)).To(Succeed())
+		time.Sleep(100 * time.Millisecond)
+		listener, err = net.Listen("tcp", address)
+		Expect(err).NotTo(HaveOccurred())
+		defer listener.Close()
+		lines := graphiteServer(listener)
+
+		Eventually(lines).Should(Receive(Equal("moreping.tcp.10_0_0_1.22.loss_ratio 0.25 1500000000")))
+		Expect(sink.Close()).To(Succeed())
+	})
+
+	It("should not wait for Graphite and report the lines not sent when closed", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		address := listener.Addr().String()
+		listener.Close()
+		sink := moreping.NewGraphiteSink(address, moreping.GraphiteOptions{})
+
+		Expect(sink.WriteBatch(batch)).To(Succeed())
+		Expect(sink.Close()).To(MatchError(ContainSubstring("unable to send 4 lines")))
+	})
+})
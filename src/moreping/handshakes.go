@@ -0,0 +1,260 @@
+package moreping
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The protocols supported by the handshake pinger.
+const (
+	RedisProtocol    = "redis"
+	PostgresProtocol = "postgres"
+	MySQLProtocol    = "mysql"
+)
+
+// handshakeFunc performs the handshake on an established connection filling
+// the server error and version. The returned error is a client side error.
+type handshakeFunc func(conn net.Conn, handshakeCall *HandshakeCall) error
+
+var handshakes = map[string]struct {
+	defaultPort int
+	handshake   handshakeFunc
+}{
+	RedisProtocol:    {6379, redisHandshake},
+	PostgresProtocol: {5432, postgresHandshake},
+	MySQLProtocol:    {3306, mysqlHandshake},
+}
+
+// HandshakePinger performs the minimal protocol handshake with database and cache servers,
+// which catches the hung servers still accepting TCP connections.
+type HandshakePinger interface {
+	HandshakeIP(targetIP string, targetPort int) HandshakeCall
+	SpawnHandshakes(ips []string, targetPort int)
+}
+
+type handshakePinger struct {
+	protocol  string
+	timeout   time.Duration
+	handshake handshakeFunc
+	port      int
+	msgChan   chan HandshakeCall
+}
+
+// NewHandshakePinger creates a new instance of the handshake pinger for one of
+// the supported protocols: "redis", "postgres" or "mysql".
+func NewHandshakePinger(protocol string, timeout time.Duration, handshakeChan chan HandshakeCall) (HandshakePinger, error) {
+	h, ok := handshakes[protocol]
+	if !ok {
+		return nil, fmt.Errorf("unknown handshake protocol: %s", protocol)
+	}
+	return &handshakePinger{
+		protocol:  protocol,
+		timeout:   timeout,
+		handshake: h.handshake,
+		port:      h.defaultPort,
+		msgChan:   handshakeChan,
+	}, nil
+}
+
+// HandshakeIP connects to the given IP address and TCP port (0 means the default port
+// of the protocol) then performs the protocol handshake. The call is successful if the
+// server answers at protocol level without reporting errors about its own health
+// (e.g. a server asking for authentication is healthy).
+func (h *handshakePinger) HandshakeIP(targetIP string, targetPort int) HandshakeCall {
+	if targetPort == 0 {
+		targetPort = h.port
+	}
+	handshakeCall := HandshakeCall{IpAddress: targetIP, TcpPort: targetPort, Protocol: h.protocol, Latency: InfiniteLatency}
+
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(targetIP, strconv.Itoa(targetPort)), h.timeout)
+	if err != nil {
+		handshakeCall.Message = err.Error()
+		return handshakeCall
+	}
+	defer conn.Close()
+	connected := time.Now()
+	handshakeCall.ConnectLatency = connected.Sub(start)
+
+	conn.SetDeadline(start.Add(h.timeout))
+	if err := h.handshake(conn, &handshakeCall); err != nil {
+		handshakeCall.Message = err.Error()
+		return handshakeCall
+	}
+	handshakeCall.HandshakeLatency = time.Now().Sub(connected)
+	handshakeCall.Latency = handshakeCall.ConnectLatency + handshakeCall.HandshakeLatency
+	handshakeCall.Success = handshakeCall.ServerError == "" || healthyServerError(h.protocol, handshakeCall.ServerError)
+	return handshakeCall
+}
+
+// SpawnHandshakes performs the handshakes with a list of input IP addresses.
+// This is an asynchronous process publishing the outcomes to a channel.
+func (h *handshakePinger) SpawnHandshakes(ips []string, targetPort int) {
+	for _, targetIP := range ips {
+		go func(targetIP string) {
+			h.msgChan <- h.HandshakeIP(targetIP, targetPort)
+		}(targetIP)
+	}
+	Logger.Printf("Done spawning %v %s handshakes\n", len(ips), h.protocol)
+}
+
+// healthyServerError tells whether the error reported by the server still means
+// that the server is up and running (i.e. it is just refusing an anonymous client).
+func healthyServerError(protocol string, serverError string) bool {
+	switch protocol {
+	case RedisProtocol:
+		return strings.HasPrefix(serverError, "NOAUTH")
+	case PostgresProtocol:
+		// invalid authorization (class 28) and non existing database
+		return strings.HasPrefix(serverError, "28") || strings.HasPrefix(serverError, "3D000")
+	}
+	return false
+}
+
+// redisHandshake sends a PING command expecting a PONG reply.
+func redisHandshake(conn net.Conn, handshakeCall *HandshakeCall) error {
+	if _, err := conn.Write([]byte("*1\r\n$4\r\nPING\r\n")); err != nil {
+		return err
+	}
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	reply = strings.TrimRight(reply, "\r\n")
+	switch {
+	case reply == "+PONG":
+		return nil
+	case strings.HasPrefix(reply, "-"):
+		handshakeCall.ServerError = reply[1:]
+		return nil
+	}
+	return fmt.Errorf("unexpected Redis reply: %q", reply)
+}
+
+// postgresHandshake sends an SSL request, then (over TLS if supported) a startup
+// message for a dummy user. The server either asks for authentication or replies
+// with an error response.
+func postgresHandshake(conn net.Conn, handshakeCall *HandshakeCall) error {
+	sslRequest := make([]byte, 8)
+	binary.BigEndian.PutUint32(sslRequest[0:4], 8)
+	binary.BigEndian.PutUint32(sslRequest[4:8], 80877103)
+	if _, err := conn.Write(sslRequest); err != nil {
+		return err
+	}
+	answer := make([]byte, 1)
+	if _, err := io.ReadFull(conn, answer); err != nil {
+		return err
+	}
+	var rw io.ReadWriter = conn
+	switch answer[0] {
+	case 'S':
+		// this is a health check: the server certificate is not verified
+		tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+		if err := tlsConn.Handshake(); err != nil {
+			return err
+		}
+		rw = tlsConn
+	case 'N':
+	case 'E':
+		// servers not supporting the SSL request reply with an error
+		return postgresErrorResponse(conn, handshakeCall)
+	default:
+		return fmt.Errorf("unexpected PostgreSQL answer to the SSL request: %q", answer[0])
+	}
+
+	params := []byte{}
+	for _, param := range []string{"user", "moreping", "database", "moreping", "application_name", "moreping"} {
+		params = append(append(params, param...), 0)
+	}
+	params = append(params, 0)
+	startup := make([]byte, 8, 8+len(params))
+	binary.BigEndian.PutUint32(startup[0:4], uint32(8+len(params)))
+	binary.BigEndian.PutUint32(startup[4:8], 3<<16) // protocol version 3.0
+	startup = append(startup, params...)
+	if _, err := rw.Write(startup); err != nil {
+		return err
+	}
+
+	msgType := make([]byte, 1)
+	if _, err := io.ReadFull(rw, msgType); err != nil {
+		return err
+	}
+	switch msgType[0] {
+	case 'R':
+		return nil
+	case 'E':
+		return postgresErrorResponse(rw, handshakeCall)
+	}
+	return fmt.Errorf("unexpected PostgreSQL message type: %q", msgType[0])
+}
+
+// postgresErrorResponse parses an error response (the type byte has already been read)
+// reporting it as "<SQLSTATE code> <message>".
+func postgresErrorResponse(r io.Reader, handshakeCall *HandshakeCall) error {
+	length := make([]byte, 4)
+	if _, err := io.ReadFull(r, length); err != nil {
+		return err
+	}
+	size := int(binary.BigEndian.Uint32(length)) - 4
+	if size < 0 || size > 64*1024 {
+		return fmt.Errorf("invalid PostgreSQL error response length: %d", size)
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return err
+	}
+	fields := map[byte]string{}
+	for _, field := range bytes.Split(body, []byte{0}) {
+		if len(field) > 1 {
+			fields[field[0]] = string(field[1:])
+		}
+	}
+	handshakeCall.ServerError = strings.TrimSpace(fields['C'] + " " + fields['M'])
+	return nil
+}
+
+// mysqlHandshake reads the greeting the server sends right after the connection,
+// which is either a handshake packet (protocol version 10) or an error packet.
+func mysqlHandshake(conn net.Conn, handshakeCall *HandshakeCall) error {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	size := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+	if size == 0 {
+		return fmt.Errorf("empty MySQL greeting")
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		return err
+	}
+	switch payload[0] {
+	case 10:
+		end := bytes.IndexByte(payload[1:], 0)
+		if end < 0 {
+			return fmt.Errorf("invalid MySQL greeting")
+		}
+		handshakeCall.ServerVersion = string(payload[1 : 1+end])
+		return nil
+	case 0xff:
+		if len(payload) < 3 {
+			return fmt.Errorf("invalid MySQL error packet")
+		}
+		code := binary.LittleEndian.Uint16(payload[1:3])
+		message := payload[3:]
+		if len(message) > 6 && message[0] == '#' {
+			message = message[6:] // skip the SQL state
+		}
+		handshakeCall.ServerError = fmt.Sprintf("%d %s", code, message)
+		return nil
+	}
+	return fmt.Errorf("unsupported MySQL protocol version: %d", payload[0])
+}
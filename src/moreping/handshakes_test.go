@@ -0,0 +1,193 @@
+package moreping_test
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/tappoz/moreping/src/moreping"
+)
+
+func redisStandIn(reply string) func(conn net.Conn) {
+	return func(conn net.Conn) {
+		request := make([]byte, len("*1\r\n$4\r\nPING\r\n"))
+		if _, err := io.ReadFull(conn, request); err == nil {
+			conn.Write([]byte(reply))
+		}
+	}
+}
+
+func postgresStandIn(startupReply []byte) func(conn net.Conn) {
+	return func(conn net.Conn) {
+		sslRequest := make([]byte, 8)
+		if _, err := io.ReadFull(conn, sslRequest); err != nil {
+			return
+		}
+		conn.Write([]byte("N"))
+		length := make([]byte, 4)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return
+		}
+		startup := make([]byte, binary.BigEndian.Uint32(length)-4)
+		if _, err := io.ReadFull(conn, startup); err != nil {
+			return
+		}
+		conn.Write(startupReply)
+	}
+}
+
+func postgresErrorResponse(code string, message string) []byte {
+	body := []byte("SFATAL\x00C" + code + "\x00M" + message + "\x00\x00")
+	msg := []byte{'E', 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(msg[1:5], uint32(4+len(body)))
+	return append(msg, body...)
+}
+
+func mysqlStandIn(payload []byte) func(conn net.Conn) {
+	return func(conn net.Conn) {
+		header := []byte{byte(len(payload)), byte(len(payload) >> 8), byte(len(payload) >> 16), 0}
+		conn.Write(append(header, payload...))
+	}
+}
+
+var _ = Describe("Handshakes", func() {
+
+	localhostIP := "127.0.0.1"
+	handshakeTimeout := 1 * time.Second
+
+	newPinger := func(protocol string) moreping.HandshakePinger {
+		handshakePinger, err := moreping.NewHandshakePinger(protocol, handshakeTimeout, make(chan moreping.HandshakeCall))
+		Expect(err).NotTo(HaveOccurred())
+		return handshakePinger
+	}
+
+	It("should refuse unknown protocols", func() {
+		_, err := moreping.NewHandshakePinger("memcached", handshakeTimeout, nil)
+
+		Expect(err).To(HaveOccurred())
+	})
+
+	Describe("Redis", func() {
+
+		It("should succeed when the server replies PONG", func() {
+			listener, port := standInServer(redisStandIn("+PONG\r\n"))
+			defer listener.Close()
+
+			handshakeCall := newPinger(moreping.RedisProtocol).HandshakeIP(localhostIP, port)
+
+			Expect(handshakeCall.Success).To(Equal(true))
+			Expect(handshakeCall.Protocol).To(Equal(moreping.RedisProtocol))
+			Expect(handshakeCall.ServerError).To(BeEmpty())
+			Expect(handshakeCall.Latency).To(Equal(handshakeCall.ConnectLatency + handshakeCall.HandshakeLatency))
+			Expect(handshakeCall.Latency).Should(BeNumerically("<=", handshakeTimeout))
+		})
+
+		It("should report the server errors", func() {
+			listener, port := standInServer(redisStandIn("-LOADING Redis is loading the dataset in memory\r\n"))
+			defer listener.Close()
+
+			handshakeCall := newPinger(moreping.RedisProtocol).HandshakeIP(localhostIP, port)
+
+			Expect(handshakeCall.Success).To(Equal(false))
+			Expect(handshakeCall.ServerError).To(Equal("LOADING Redis is loading the dataset in memory"))
+		})
+
+		It("should consider healthy a server asking for authentication", func() {
+			listener, port := standInServer(redisStandIn("-NOAUTH Authentication required.\r\n"))
+			defer listener.Close()
+
+			handshakeCall := newPinger(moreping.RedisProtocol).HandshakeIP(localhostIP, port)
+
+			Expect(handshakeCall.Success).To(Equal(true))
+			Expect(handshakeCall.ServerError).To(HavePrefix("NOAUTH"))
+		})
+
+		It("should timeout on a hung server accepting connections", func() {
+			listener, port := standInServer(func(conn net.Conn) { time.Sleep(2 * handshakeTimeout) })
+			defer listener.Close()
+
+			handshakeCall := newPinger(moreping.RedisProtocol).HandshakeIP(localhostIP, port)
+
+			Expect(handshakeCall.Success).To(Equal(false))
+			Expect(handshakeCall.Message).To(ContainSubstring("timeout"))
+			Expect(handshakeCall.Latency).To(Equal(moreping.InfiniteLatency))
+		})
+	})
+
+	Describe("PostgreSQL", func() {
+
+		It("should succeed when the server asks for authentication", func() {
+			authRequest := []byte{'R', 0, 0, 0, 8, 0, 0, 0, 3}
+			listener, port := standInServer(postgresStandIn(authRequest))
+			defer listener.Close()
+
+			handshakeCall := newPinger(moreping.PostgresProtocol).HandshakeIP(localhostIP, port)
+
+			Expect(handshakeCall.Success).To(Equal(true))
+			Expect(handshakeCall.ServerError).To(BeEmpty())
+		})
+
+		It("should report the server errors with their SQLSTATE code", func() {
+			listener, port := standInServer(postgresStandIn(postgresErrorResponse("53300", "sorry, too many clients already")))
+			defer listener.Close()
+
+			handshakeCall := newPinger(moreping.PostgresProtocol).HandshakeIP(localhostIP, port)
+
+			Expect(handshakeCall.Success).To(Equal(false))
+			Expect(handshakeCall.ServerError).To(Equal("53300 sorry, too many clients already"))
+		})
+
+		It("should consider healthy a server refusing the unknown user", func() {
+			listener, port := standInServer(postgresStandIn(postgresErrorResponse("28000", `no pg_hba.conf entry for user "moreping"`)))
+			defer listener.Close()
+
+			handshakeCall := newPinger(moreping.PostgresProtocol).HandshakeIP(localhostIP, port)
+
+			Expect(handshakeCall.Success).To(Equal(true))
+			Expect(handshakeCall.ServerError).To(HavePrefix("28000"))
+		})
+	})
+
+	Describe("MySQL", func() {
+
+		It("should succeed reading the server greeting and its version", func() {
+			greeting := append([]byte{10}, []byte("8.0.36\x00rest-of-the-greeting")...)
+			listener, port := standInServer(mysqlStandIn(greeting))
+			defer listener.Close()
+
+			handshakeCall := newPinger(moreping.MySQLProtocol).HandshakeIP(localhostIP, port)
+
+			Expect(handshakeCall.Success).To(Equal(true))
+			Expect(handshakeCall.ServerVersion).To(Equal("8.0.36"))
+		})
+
+		It("should report the server errors", func() {
+			errPacket := append([]byte{0xff, 0x10, 0x04}, []byte("#08004Too many connections")...)
+			listener, port := standInServer(mysqlStandIn(errPacket))
+			defer listener.Close()
+
+			handshakeCall := newPinger(moreping.MySQLProtocol).HandshakeIP(localhostIP, port)
+
+			Expect(handshakeCall.Success).To(Equal(false))
+			Expect(handshakeCall.ServerError).To(Equal("1040 Too many connections"))
+		})
+	})
+
+	It("should asynchronously perform the handshakes publishing them to a channel", func() {
+		listener, port := standInServer(redisStandIn("+PONG\r\n"))
+		defer listener.Close()
+		handshakeChan := make(chan moreping.HandshakeCall)
+		handshakePinger, err := moreping.NewHandshakePinger(moreping.RedisProtocol, handshakeTimeout, handshakeChan)
+		Expect(err).NotTo(HaveOccurred())
+
+		handshakePinger.SpawnHandshakes([]string{localhostIP}, port)
+		handshakeCall := <-handshakeChan
+
+		Expect(handshakeCall.IpAddress).To(Equal(localhostIP))
+		Expect(handshakeCall.TcpPort).To(Equal(port))
+		Expect(handshakeCall.Success).To(Equal(true))
+	})
+})
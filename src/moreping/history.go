@@ -0,0 +1,309 @@
+package moreping
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The segments of the history store are daily (UTC) NDJSON files, e.g. moreping-2017-07-14.ndjson
+const (
+	historySegmentPrefix = "moreping-"
+	historySegmentSuffix = ".ndjson"
+	historySegmentLayout = "2006-01-02"
+)
+
+// maxHistoryRecord is the longest line read back from the history (a batch with many latencies).
+const maxHistoryRecord = 4 * 1024 * 1024
+
+// HistoryFilter selects the records of the history. The zero value selects all of them.
+type HistoryFilter struct {
+	Probe   string    // the probe type, any if empty
+	Targets []string  // the IP addresses, any if empty
+	Port    int       // the port, any if 0
+	From    time.Time // inclusive, unbounded if zero
+	To      time.Time // exclusive, unbounded if zero
+}
+
+func (f HistoryFilter) matches(probe string, target string, port int, timestamp time.Time) bool {
+	if f.Probe != "" && f.Probe != probe {
+		return false
+	}
+	if len(f.Targets) > 0 && !containsString(f.Targets, target) {
+		return false
+	}
+	if f.Port != 0 && f.Port != port {
+		return false
+	}
+	if !f.From.IsZero() && timestamp.Before(f.From) {
+		return false
+	}
+	return f.To.IsZero() || timestamp.Before(f.To)
+}
+
+// History holds the calls and the batches selected from the records.
+type History struct {
+	Calls   []ProbeResult
+	Batches []ProbeBatch
+}
+
+// ReadHistory appends to the history the records of a NDJSON stream (as written by the
+// history store or by the NDJSON sink) selected by the filter. The lines which are not
+// records of the current schema version are skipped (e.g. a line truncated by a crash).
+func ReadHistory(r io.Reader, filter HistoryFilter, history *History) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxHistoryRecord)
+	for scanner.Scan() {
+		header := struct {
+			Version int    `json:"version"`
+			Kind    string `json:"kind"`
+		}{}
+		if json.Unmarshal(scanner.Bytes(), &header) != nil || header.Version != JSONSchemaVersion {
+			continue
+		}
+		switch header.Kind {
+		case JSONCallKind:
+			call := JSONCall{}
+			if json.Unmarshal(scanner.Bytes(), &call) != nil {
+				continue
+			}
+			if filter.matches(call.Probe, call.Target, call.Port, call.Timestamp) {
+				history.Calls = append(history.Calls, call.ProbeResult())
+			}
+		case JSONBatchKind:
+			batch := JSONBatch{}
+			if json.Unmarshal(scanner.Bytes(), &batch) != nil {
+				continue
+			}
+			if filter.matches(batch.Probe, batch.Target, batch.Port, batch.Timestamp) {
+				history.Batches = append(history.Batches, batch.ProbeBatch())
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// Sort sorts the calls and the batches chronologically
+// (e.g. after reading several NDJSON streams).
+func (h *History) Sort() {
+	sort.SliceStable(h.Calls, func(i, j int) bool { return h.Calls[i].Timestamp.Before(h.Calls[j].Timestamp) })
+	sort.SliceStable(h.Batches, func(i, j int) bool { return h.Batches[i].Timestamp.Before(h.Batches[j].Timestamp) })
+}
+
+// SummarizeBatches aggregates the batches of every probe type, target and port (in this order):
+// the loss ratio and the average latency are weighted by the number of calls of each batch,
+// the minimum and the maximum latencies are those of the successful calls,
+// and the timestamp is that of the last batch.
+func SummarizeBatches(batches []ProbeBatch) []ProbeBatch {
+	summaries := map[seriesKey]*ProbeBatch{}
+	totalLatency := map[seriesKey]time.Duration{}
+	losses := map[seriesKey]float64{}
+	for _, batch := range batches {
+		key := seriesKey{probe: batch.Probe, target: batch.IpAddress, port: batch.Port}
+		summary, ok := summaries[key]
+		if !ok {
+			summary = &ProbeBatch{Probe: batch.Probe, IpAddress: batch.IpAddress, Port: batch.Port, Failures: map[string]int{}}
+			summaries[key] = summary
+		}
+		if batch.Timestamp.After(summary.Timestamp) {
+			summary.Timestamp = batch.Timestamp
+		}
+		summary.Experiments += batch.Experiments
+		totalLatency[key] += batch.AvgLatency * time.Duration(batch.Experiments)
+		losses[key] += float64(batch.PctPcktLoss) * float64(batch.Experiments)
+		for _, latency := range batch.Latencies {
+			if len(summary.Latencies) == 0 || latency < summary.MinLatency {
+				summary.MinLatency = latency
+			}
+			if latency > summary.MaxLatency {
+				summary.MaxLatency = latency
+			}
+			summary.Latencies = append(summary.Latencies, latency)
+		}
+		for message, count := range batch.Failures {
+			summary.Failures[message] += count
+		}
+	}
+
+	keys := make([]seriesKey, 0, len(summaries))
+	for key, summary := range summaries {
+		if summary.Experiments > 0 {
+			summary.AvgLatency = totalLatency[key] / time.Duration(summary.Experiments)
+			summary.PctPcktLoss = float32(losses[key] / float64(summary.Experiments))
+		}
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].probe != keys[j].probe {
+			return keys[i].probe < keys[j].probe
+		}
+		if keys[i].target != keys[j].target {
+			return keys[i].target < keys[j].target
+		}
+		return keys[i].port < keys[j].port
+	})
+	result := make([]ProbeBatch, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, *summaries[key])
+	}
+	return result
+}
+
+// HistoryStore is a result sink recording every call and batch on disk, appended to daily
+// segments in the NDJSON format of the JSON output. The retention is applied by removing
+// the whole segments older than the retention period (when the store is opened and at
+// every new segment).
+type HistoryStore struct {
+	mutex     sync.Mutex
+	dir       string
+	retention time.Duration
+	segment   string
+	file      *os.File
+}
+
+// OpenHistoryStore opens (or creates) the store in a directory, keeping the records for the
+// retention period (forever if 0). Close it to close the current segment.
+func OpenHistoryStore(dir string, retention time.Duration) (*HistoryStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	store := &HistoryStore{dir: dir, retention: retention}
+	if err := store.Purge(time.Now()); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// WriteResult records the call.
+func (h *HistoryStore) WriteResult(result ProbeResult) error {
+	return h.append(result.Timestamp, NewJSONCall(result))
+}
+
+// WriteBatch records the batch.
+func (h *HistoryStore) WriteBatch(batch ProbeBatch) error {
+	return h.append(batch.Timestamp, NewJSONBatch(batch))
+}
+
+// append writes the record as a single line (a single write) to the segment of its day.
+func (h *HistoryStore) append(timestamp time.Time, record interface{}) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	segment := historySegmentPrefix + timestamp.UTC().Format(historySegmentLayout) + historySegmentSuffix
+	if segment != h.segment {
+		if h.file != nil {
+			h.file.Close()
+			h.file, h.segment = nil, ""
+		}
+		file, err := os.OpenFile(filepath.Join(h.dir, segment), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return err
+		}
+		h.file, h.segment = file, segment
+		if err := h.purge(time.Now()); err != nil {
+			Logger.Printf("Unable to apply the retention of the history: %s\n", err)
+		}
+	}
+	_, err = h.file.Write(append(line, '\n'))
+	return err
+}
+
+// Purge removes the segments whose records are all older than the retention period.
+func (h *HistoryStore) Purge(now time.Time) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.purge(now)
+}
+
+func (h *HistoryStore) purge(now time.Time) error {
+	if h.retention <= 0 {
+		return nil
+	}
+	segments, err := h.segments()
+	if err != nil {
+		return err
+	}
+	for day, segment := range segments {
+		if segment != h.segment && !day.AddDate(0, 0, 1).After(now.Add(-h.retention)) {
+			if err := os.Remove(filepath.Join(h.dir, segment)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// segments lists the segment files by day.
+func (h *HistoryStore) segments() (map[time.Time]string, error) {
+	files, err := ioutil.ReadDir(h.dir)
+	if err != nil {
+		return nil, err
+	}
+	segments := map[time.Time]string{}
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasPrefix(name, historySegmentPrefix) || !strings.HasSuffix(name, historySegmentSuffix) {
+			continue
+		}
+		day, err := time.Parse(historySegmentLayout, strings.TrimSuffix(strings.TrimPrefix(name, historySegmentPrefix), historySegmentSuffix))
+		if err != nil {
+			continue
+		}
+		segments[day] = name
+	}
+	return segments, nil
+}
+
+// Query reads the records selected by the filter, in chronological order
+// (only the segments of the days within the time range are read).
+func (h *HistoryStore) Query(filter HistoryFilter) (History, error) {
+	history := History{}
+	h.mutex.Lock()
+	segments, err := h.segments()
+	h.mutex.Unlock()
+	if err != nil {
+		return history, err
+	}
+	for day, segment := range segments {
+		if !filter.From.IsZero() && !day.AddDate(0, 0, 1).After(filter.From) {
+			continue
+		}
+		if !filter.To.IsZero() && !day.Before(filter.To) {
+			continue
+		}
+		file, err := os.Open(filepath.Join(h.dir, segment))
+		if err != nil {
+			return history, err
+		}
+		err = ReadHistory(file, filter, &history)
+		file.Close()
+		if err != nil {
+			return history, fmt.Errorf("unable to read %s: %s", segment, err)
+		}
+	}
+	history.Sort()
+	return history, nil
+}
+
+// Close closes the current segment.
+func (h *HistoryStore) Close() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.file == nil {
+		return nil
+	}
+	err := h.file.Close()
+	h.file, h.segment = nil, ""
+	return err
+}
@@ -0,0 +1,122 @@
+package moreping_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/tappoz/moreping/src/moreping"
+)
+
+var _ = Describe("History", func() {
+
+	day := time.Date(2017, time.July, 14, 2, 40, 0, 0, time.UTC)
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "moreping-history")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	batchAt := func(target string, timestamp time.Time, loss float32, latencies ...time.Duration) moreping.ProbeBatch {
+		batch := moreping.ProbeBatch{Probe: "tcp", IpAddress: target, Port: 22, Timestamp: timestamp, Experiments: 4,
+			PctPcktLoss: loss, AvgLatency: 2 * time.Millisecond, Latencies: latencies, Failures: map[string]int{}}
+		for _, latency := range latencies {
+			if batch.MinLatency == 0 || latency < batch.MinLatency {
+				batch.MinLatency = latency
+			}
+			if latency > batch.MaxLatency {
+				batch.MaxLatency = latency
+			}
+		}
+		return batch
+	}
+
+	It("should record the calls and the batches and query them back", func() {
+		store, err := moreping.OpenHistoryStore(dir, 0)
+		Expect(err).NotTo(HaveOccurred())
+		call := moreping.ProbeResult{Probe: "tcp", IpAddress: "10.0.0.1", Port: 22, Timestamp: day, Success: true,
+			Latency: 1500 * time.Microsecond, Details: map[string]string{"port_state": "open"}}
+		batch := batchAt("10.0.0.1", day, 0.25, time.Millisecond, 3*time.Millisecond)
+		Expect(store.WriteResult(call)).To(Succeed())
+		Expect(store.WriteBatch(batch)).To(Succeed())
+		Expect(store.WriteBatch(batchAt("10.0.0.2", day, 0))).To(Succeed())
+		Expect(store.Close()).To(Succeed())
+
+		history, err := store.Query(moreping.HistoryFilter{Targets: []string{"10.0.0.1"}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(history.Calls).To(HaveLen(1))
+		Expect(history.Calls[0].Timestamp.Equal(day)).To(BeTrue())
+		call.Timestamp = history.Calls[0].Timestamp
+		Expect(history.Calls[0]).To(Equal(call))
+		Expect(history.Batches).To(HaveLen(1))
+		Expect(history.Batches[0].Latencies).To(Equal(batch.Latencies))
+		Expect(history.Batches[0].PctPcktLoss).To(Equal(batch.PctPcktLoss))
+		Expect(history.Batches[0].AvgLatency).To(Equal(batch.AvgLatency))
+		Expect(history.Batches[0].MaxLatency).To(Equal(3 * time.Millisecond))
+	})
+
+	It("should append to daily segments, query a time range and apply the retention", func() {
+		start := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -2).Add(2*time.Hour + 40*time.Minute)
+		store, err := moreping.OpenHistoryStore(dir, 0)
+		Expect(err).NotTo(HaveOccurred())
+		for days := 0; days < 3; days++ {
+			Expect(store.WriteBatch(batchAt("10.0.0.1", start.AddDate(0, 0, days), 0))).To(Succeed())
+		}
+		Expect(store.Close()).To(Succeed())
+		files, _ := filepath.Glob(filepath.Join(dir, "moreping-*.ndjson"))
+		Expect(files).To(HaveLen(3))
+
+		history, err := store.Query(moreping.HistoryFilter{From: start.Add(time.Hour), To: start.AddDate(0, 0, 2)})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(history.Batches).To(HaveLen(1))
+		Expect(history.Batches[0].Timestamp.Equal(start.AddDate(0, 0, 1))).To(BeTrue())
+
+		// only the segment of the day before yesterday is entirely older than a day
+		store, err = moreping.OpenHistoryStore(dir, 24*time.Hour)
+		Expect(err).NotTo(HaveOccurred())
+		history, err = store.Query(moreping.HistoryFilter{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(history.Batches).To(HaveLen(2))
+		Expect(history.Batches[0].Timestamp.Equal(start.AddDate(0, 0, 1))).To(BeTrue())
+	})
+
+	It("should skip the lines which are not records", func() {
+		history := moreping.History{}
+		input := `{"version":1,"kind":"batch","timestamp":"2017-07-14T02:40:00Z","probe":"icmp","target":"10.0.0.1","port":0,"experiments":2,"loss_ratio":1,"avg_latency_ms":9999,"min_latency_ms":null,"max_latency_ms":null,"latencies_ms":[],"failures":{"timeout":2}}
+not json
+{"version":99,"kind":"batch"}
+{"version":1,"kind":"call","timestamp":"2017-07-14T02:40:00Z","probe":"icmp","tar`
+		Expect(moreping.ReadHistory(strings.NewReader(input), moreping.HistoryFilter{Probe: "icmp"}, &history)).To(Succeed())
+		Expect(history.Calls).To(BeEmpty())
+		Expect(history.Batches).To(HaveLen(1))
+		Expect(history.Batches[0].AvgLatency).To(Equal(moreping.InfiniteLatency))
+		Expect(history.Batches[0].Failures).To(Equal(map[string]int{"timeout": 2}))
+	})
+
+	It("should summarize the batches of every target", func() {
+		summaries := moreping.SummarizeBatches([]moreping.ProbeBatch{
+			batchAt("10.0.0.2", day, 0, time.Millisecond),
+			batchAt("10.0.0.1", day, 0.5, 2*time.Millisecond, 4*time.Millisecond),
+			batchAt("10.0.0.1", day.Add(time.Minute), 0, time.Millisecond, 3*time.Millisecond, 5*time.Millisecond),
+		})
+		Expect(summaries).To(HaveLen(2))
+		Expect(summaries[0].IpAddress).To(Equal("10.0.0.1"))
+		Expect(summaries[0].Experiments).To(Equal(8))
+		Expect(summaries[0].PctPcktLoss).To(Equal(float32(0.25)))
+		Expect(summaries[0].MinLatency).To(Equal(time.Millisecond))
+		Expect(summaries[0].MaxLatency).To(Equal(5 * time.Millisecond))
+		Expect(summaries[0].Latencies).To(HaveLen(5))
+		Expect(summaries[0].Timestamp).To(Equal(day.Add(time.Minute)))
+		Expect(summaries[1].IpAddress).To(Equal("10.0.0.2"))
+	})
+})
@@ -0,0 +1,171 @@
+package moreping
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// HopWatcher continuously re-probes every hop on the path to a target IP address
+// keeping the loss and latency statistics of each hop (mtr-like).
+// As for the ICMP pinger, on Linux this must be run as root user.
+type HopWatcher interface {
+	ProbeHops()
+	Stats() []HopStats
+}
+
+type hopWatcher struct {
+	targetIP   string
+	maxHops    int
+	timeout    time.Duration
+	statsChan  chan []HopStats
+	mutex      sync.Mutex
+	running    bool
+	seq        int
+	pathLength int // 0 until the target has been reached once
+	hops       []*hopAccumulator
+}
+
+// NewHopWatcher creates a new instance of the hop watcher. At every round of
+// calls a snapshot of the statistics of all the hops is published to the channel.
+func NewHopWatcher(targetIP string, maxHops int, timeout time.Duration, hopStatsChan chan []HopStats) HopWatcher {
+	return &hopWatcher{
+		targetIP:  targetIP,
+		maxHops:   maxHops,
+		timeout:   timeout,
+		statsChan: hopStatsChan,
+	}
+}
+
+// ProbeHops performs a round of TTL limited ICMP calls, one for each hop on the path.
+// The first rounds discover the path length: hops after the first one answered
+// by the target are discarded. A round is skipped if the previous one is still running,
+// so this can be safely scheduled via `Schedule(...)`.
+func (h *hopWatcher) ProbeHops() {
+	h.mutex.Lock()
+	if h.running {
+		h.mutex.Unlock()
+		return
+	}
+	h.running = true
+	hopsToProbe := h.maxHops
+	if h.pathLength > 0 {
+		hopsToProbe = h.pathLength
+	}
+	h.seq++
+	seq := h.seq
+	h.mutex.Unlock()
+
+	calls := make([]HopCall, hopsToProbe)
+	var wg sync.WaitGroup
+	for ttl := 1; ttl <= hopsToProbe; ttl++ {
+		wg.Add(1)
+		go func(ttl int) {
+			defer wg.Done()
+			calls[ttl-1] = h.probeHop(ttl, seq)
+		}(ttl)
+	}
+	wg.Wait()
+
+	h.mutex.Lock()
+	h.record(calls)
+	h.running = false
+	snapshot := h.snapshot()
+	h.mutex.Unlock()
+
+	if h.statsChan != nil {
+		h.statsChan <- snapshot
+	}
+}
+
+// Stats returns a snapshot of the statistics collected so far for each hop.
+func (h *hopWatcher) Stats() []HopStats {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.snapshot()
+}
+
+func (h *hopWatcher) probeHop(ttl int, seq int) HopCall {
+	hopCall := HopCall{Hop: ttl}
+	socket, err := newEchoSocket()
+	if err != nil {
+		Logger.Printf("Unable to probe hop %d towards %s: %s\n", ttl, h.targetIP, err)
+		return hopCall
+	}
+	defer socket.Close()
+
+	outcome, err := socket.echo(h.targetIP, seq, echoParams{ttl: ttl, size: defaultIcmpPayloadSize}, h.timeout)
+	if err != nil {
+		Logger.Printf("Unable to probe hop %d towards %s: %s\n", ttl, h.targetIP, err)
+		return hopCall
+	}
+	hopCall.IpAddress = outcome.peer
+	hopCall.Success = outcome.peer != ""
+	hopCall.Latency = outcome.latency
+	if !hopCall.Success {
+		hopCall.Latency = InfiniteLatency
+	}
+	return hopCall
+}
+
+// record updates the statistics with a round of calls, the mutex must be held.
+func (h *hopWatcher) record(calls []HopCall) {
+	for _, call := range calls {
+		if call.Success && call.IpAddress == h.targetIP {
+			if h.pathLength == 0 || call.Hop < h.pathLength {
+				h.pathLength = call.Hop
+			}
+			break
+		}
+	}
+	if h.pathLength > 0 && len(h.hops) > h.pathLength {
+		h.hops = h.hops[:h.pathLength]
+	}
+	for _, call := range calls {
+		if h.pathLength > 0 && call.Hop > h.pathLength {
+			break
+		}
+		for len(h.hops) < call.Hop {
+			h.hops = append(h.hops, &hopAccumulator{stats: HopStats{Hop: len(h.hops) + 1}})
+		}
+		h.hops[call.Hop-1].add(call)
+	}
+}
+
+func (h *hopWatcher) snapshot() []HopStats {
+	stats := make([]HopStats, 0, len(h.hops))
+	for _, hop := range h.hops {
+		stats = append(stats, hop.stats)
+	}
+	return stats
+}
+
+// hopAccumulator keeps the running statistics of a hop
+// (Welford's algorithm for the standard deviation).
+type hopAccumulator struct {
+	stats HopStats
+	mean  float64
+	m2    float64
+}
+
+func (a *hopAccumulator) add(call HopCall) {
+	a.stats.Sent++
+	if call.Success {
+		a.stats.Received++
+		a.stats.IpAddress = call.IpAddress
+		a.stats.Last = call.Latency
+		if a.stats.Received == 1 || call.Latency < a.stats.Best {
+			a.stats.Best = call.Latency
+		}
+		if call.Latency > a.stats.Worst {
+			a.stats.Worst = call.Latency
+		}
+		latency := float64(call.Latency)
+		delta := latency - a.mean
+		a.mean += delta / float64(a.stats.Received)
+		a.m2 += delta * (latency - a.mean)
+		a.stats.AvgLatency = time.Duration(a.mean)
+		a.stats.StdDev = time.Duration(math.Sqrt(a.m2 / float64(a.stats.Received)))
+	}
+	a.stats.PctPcktLoss = float32(a.stats.Sent-a.stats.Received) / float32(a.stats.Sent)
+}
@@ -0,0 +1,50 @@
+package moreping_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/tappoz/moreping/src/moreping"
+)
+
+var _ = Describe("Hops", func() {
+
+	Describe("Hop watcher", func() {
+
+		hopTimeout := 1 * time.Second
+		localhostIP := "127.0.0.1"
+
+		It("should discover a single hop path to localhost and keep its stats over the rounds", func() {
+			hopWatcher := moreping.NewHopWatcher(localhostIP, 5, hopTimeout, nil)
+
+			rounds := 3
+			for i := 0; i < rounds; i++ {
+				hopWatcher.ProbeHops()
+			}
+			hopStats := hopWatcher.Stats()
+
+			Expect(hopStats).To(HaveLen(1))
+			Expect(hopStats[0].Hop).To(Equal(1))
+			Expect(hopStats[0].IpAddress).To(Equal(localhostIP))
+			Expect(hopStats[0].Sent).To(Equal(rounds))
+			Expect(hopStats[0].Received).To(Equal(rounds))
+			Expect(hopStats[0].PctPcktLoss).To(Equal(float32(0.0)))
+			Expect(hopStats[0].Best).Should(BeNumerically("<=", hopStats[0].AvgLatency))
+			Expect(hopStats[0].Worst).Should(BeNumerically(">=", hopStats[0].AvgLatency))
+			Expect(hopStats[0].Worst).Should(BeNumerically("<=", hopTimeout))
+		})
+
+		It("should asynchronously publish a snapshot of the stats at every round", func() {
+			hopStatsChan := make(chan []moreping.HopStats)
+			hopWatcher := moreping.NewHopWatcher(localhostIP, 5, hopTimeout, hopStatsChan)
+
+			go hopWatcher.ProbeHops()
+			hopStats := <-hopStatsChan
+
+			Expect(hopStats).To(HaveLen(1))
+			Expect(hopStats[0].IpAddress).To(Equal(localhostIP))
+			Expect(hopStats[0].Sent).To(Equal(1))
+		})
+	})
+})
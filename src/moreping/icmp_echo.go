@@ -0,0 +1,51 @@
+package moreping
+
+import (
+	"errors"
+	"time"
+)
+
+// defaultIcmpPayloadSize is the size of the ICMP payload used by ping.
+const defaultIcmpPayloadSize = 56
+
+// errEchoUnsupported is returned when raw ICMP echo sockets are not available
+// on the current platform.
+var errEchoUnsupported = errors.New("raw ICMP echo sockets are not supported on this platform")
+
+// echoParams holds the IP and ICMP fields of a single echo request which
+// fastping does not expose (it only allows setting a timeout).
+type echoParams struct {
+	ttl          int    // 0 means the OS default
+	tos          int    // 0 means the OS default
+	size         int    // size of the ICMP payload in bytes
+	pattern      []byte // repeated to fill the payload
+	dontFragment bool
+}
+
+// echoOutcome models what came back for a single echo request: either the
+// echo reply from the target or an ICMP error sent by a router on the path.
+type echoOutcome struct {
+	peer         string // the address that answered, empty on timeout
+	latency      time.Duration
+	ttl          int // TTL of the received IP packet
+	size         int // size of the received ICMP message in bytes
+	reached      bool
+	timeExceeded bool
+	unreachable  bool
+	code         int  // ICMP code of the unreachable message
+	nextHopMTU   int  // only set on "fragmentation needed" messages
+	tooBig       bool // the request exceeds the MTU of the local interface
+}
+
+// payload builds the ICMP payload repeating the pattern up to the requested size.
+func (p echoParams) payload() []byte {
+	pattern := p.pattern
+	if len(pattern) == 0 {
+		pattern = []byte("moreping")
+	}
+	b := make([]byte, p.size)
+	for i := range b {
+		b[i] = pattern[i%len(pattern)]
+	}
+	return b
+}
@@ -0,0 +1,176 @@
+package moreping
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// echoSocket is a raw ICMP socket sending echo requests with its own
+// identifier, so that concurrent sockets can tell their replies apart.
+type echoSocket struct {
+	fd int
+	id int
+}
+
+// lastEchoID is the identifier of the last echo socket: the identifiers are allocated
+// in turn (starting from the process ID, as ping does), so that the concurrent sockets
+// of the process do not share one (every raw ICMP socket receives all the echo replies).
+var lastEchoID = uint32(os.Getpid())
+
+func newEchoSocket() (*echoSocket, error) {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_RAW, syscall.IPPROTO_ICMP)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	return &echoSocket{fd: fd, id: int(atomic.AddUint32(&lastEchoID, 1)%0xffff) + 1}, nil
+}
+
+// bind applies the source options (IP address, interface, mark) to the socket.
+func (s *echoSocket) bind(source SourceOptions) error {
+	return source.bindRaw(s.fd)
+}
+
+func (s *echoSocket) Close() error {
+	return syscall.Close(s.fd)
+}
+
+// echo sends a single echo request and waits for the matching echo reply or
+// ICMP error until the timeout expires. A timeout is not an error: the
+// returned outcome simply has an empty peer.
+func (s *echoSocket) echo(targetIP string, seq int, p echoParams, timeout time.Duration) (echoOutcome, error) {
+	outcome := echoOutcome{}
+	dst := net.ParseIP(targetIP).To4()
+	if dst == nil {
+		return outcome, &net.AddrError{Err: "not an IPv4 address", Addr: targetIP}
+	}
+	if err := s.setParams(p); err != nil {
+		return outcome, err
+	}
+
+	msg := icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Body: &icmp.Echo{ID: s.id, Seq: seq & 0xffff, Data: p.payload()},
+	}
+	wb, err := msg.Marshal(nil)
+	if err != nil {
+		return outcome, err
+	}
+	sa := &syscall.SockaddrInet4{}
+	copy(sa.Addr[:], dst)
+
+	start := time.Now()
+	if err := syscall.Sendto(s.fd, wb, 0, sa); err != nil {
+		if err == syscall.EMSGSIZE && p.dontFragment {
+			outcome.tooBig = true
+			return outcome, nil
+		}
+		return outcome, os.NewSyscallError("sendto", err)
+	}
+
+	rb := make([]byte, 65536)
+	deadline := start.Add(timeout)
+	for {
+		remaining := deadline.Sub(time.Now())
+		if remaining <= 0 {
+			return outcome, nil
+		}
+		tv := syscall.NsecToTimeval(remaining.Nanoseconds())
+		if err := syscall.SetsockoptTimeval(s.fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+			return outcome, os.NewSyscallError("setsockopt", err)
+		}
+		n, from, err := syscall.Recvfrom(s.fd, rb, 0)
+		if err == syscall.EAGAIN || err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return outcome, os.NewSyscallError("recvfrom", err)
+		}
+		if s.match(rb[:n], dst, seq&0xffff, &outcome) {
+			outcome.latency = time.Now().Sub(start)
+			if from4, ok := from.(*syscall.SockaddrInet4); ok {
+				outcome.peer = net.IP(from4.Addr[:]).String()
+			}
+			return outcome, nil
+		}
+	}
+}
+
+func (s *echoSocket) setParams(p echoParams) error {
+	if p.ttl > 0 {
+		if err := syscall.SetsockoptInt(s.fd, syscall.IPPROTO_IP, syscall.IP_TTL, p.ttl); err != nil {
+			return os.NewSyscallError("setsockopt", err)
+		}
+	}
+	if p.tos > 0 {
+		if err := syscall.SetsockoptInt(s.fd, syscall.IPPROTO_IP, syscall.IP_TOS, p.tos); err != nil {
+			return os.NewSyscallError("setsockopt", err)
+		}
+	}
+	// the "probe" mode sets the DF bit ignoring the path MTU cached by the kernel
+	pmtuDisc := syscall.IP_PMTUDISC_DONT
+	if p.dontFragment {
+		pmtuDisc = syscall.IP_PMTUDISC_PROBE
+	}
+	if err := syscall.SetsockoptInt(s.fd, syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, pmtuDisc); err != nil {
+		return os.NewSyscallError("setsockopt", err)
+	}
+	return nil
+}
+
+// match parses a received IPv4 packet and fills the outcome when the packet
+// is related to the echo request with the given destination and sequence number.
+func (s *echoSocket) match(b []byte, dst net.IP, seq int, outcome *echoOutcome) bool {
+	if len(b) < 20 {
+		return false
+	}
+	ihl := int(b[0]&0x0f) * 4
+	if len(b) < ihl+8 {
+		return false
+	}
+	ttl := int(b[8])
+	msg := b[ihl:]
+	switch msg[0] {
+	case byte(ipv4.ICMPTypeEchoReply):
+		if !bytes.Equal(b[12:16], dst) || !s.sameEcho(msg, seq) {
+			return false
+		}
+		outcome.reached = true
+	case byte(ipv4.ICMPTypeTimeExceeded), byte(ipv4.ICMPTypeDestinationUnreachable):
+		// the original IP header and the first 8 bytes of our echo request follow
+		inner := msg[8:]
+		if len(inner) < 20 {
+			return false
+		}
+		innerIhl := int(inner[0]&0x0f) * 4
+		if len(inner) < innerIhl+8 || inner[innerIhl] != byte(ipv4.ICMPTypeEcho) ||
+			!bytes.Equal(inner[16:20], dst) || !s.sameEcho(inner[innerIhl:], seq) {
+			return false
+		}
+		if msg[0] == byte(ipv4.ICMPTypeTimeExceeded) {
+			outcome.timeExceeded = true
+		} else {
+			outcome.unreachable = true
+			outcome.code = int(msg[1])
+			if outcome.code == 4 {
+				outcome.nextHopMTU = int(binary.BigEndian.Uint16(msg[6:8]))
+			}
+		}
+	default:
+		return false
+	}
+	outcome.ttl = ttl
+	outcome.size = len(msg)
+	return true
+}
+
+func (s *echoSocket) sameEcho(b []byte, seq int) bool {
+	return int(binary.BigEndian.Uint16(b[4:6])) == s.id && int(binary.BigEndian.Uint16(b[6:8])) == seq
+}
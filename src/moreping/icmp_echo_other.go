@@ -0,0 +1,26 @@
+//go:build !linux
+// +build !linux
+
+package moreping
+
+import (
+	"time"
+)
+
+type echoSocket struct{}
+
+func newEchoSocket() (*echoSocket, error) {
+	return nil, errEchoUnsupported
+}
+
+func (s *echoSocket) bind(source SourceOptions) error {
+	return errEchoUnsupported
+}
+
+func (s *echoSocket) Close() error {
+	return nil
+}
+
+func (s *echoSocket) echo(targetIP string, seq int, p echoParams, timeout time.Duration) (echoOutcome, error) {
+	return echoOutcome{}, errEchoUnsupported
+}
@@ -0,0 +1,327 @@
+package moreping
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The measurements of the InfluxDB line protocol.
+const (
+	InfluxCallMeasurement  = "moreping_call"
+	InfluxBatchMeasurement = "moreping_batch"
+)
+
+var (
+	influxKeyEscaper    = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+	influxStringEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+)
+
+// influxLine builds a line of the InfluxDB line protocol, with the tags sorted by key
+// (as recommended) and the fields in the given order.
+type influxLine struct {
+	buf bytes.Buffer
+	sep byte
+}
+
+func newInfluxLine(measurement string, tags map[string]string) *influxLine {
+	line := &influxLine{sep: ' '}
+	line.buf.WriteString(strings.NewReplacer(",", `\,`, " ", `\ `).Replace(measurement))
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if tags[key] == "" {
+			continue // empty tag values are not valid
+		}
+		fmt.Fprintf(&line.buf, ",%s=%s", influxKeyEscaper.Replace(key), influxKeyEscaper.Replace(tags[key]))
+	}
+	return line
+}
+
+func (l *influxLine) field(key string, value string) {
+	l.buf.WriteByte(l.sep)
+	l.buf.WriteString(influxKeyEscaper.Replace(key))
+	l.buf.WriteByte('=')
+	l.buf.WriteString(value)
+	l.sep = ','
+}
+
+func (l *influxLine) float(key string, value float64) {
+	l.field(key, strconv.FormatFloat(value, 'f', -1, 64))
+}
+
+func (l *influxLine) int(key string, value int) {
+	l.field(key, strconv.Itoa(value)+"i")
+}
+
+func (l *influxLine) bool(key string, value bool) {
+	l.field(key, strconv.FormatBool(value))
+}
+
+func (l *influxLine) string(key string, value string) {
+	l.field(key, `"`+influxStringEscaper.Replace(value)+`"`)
+}
+
+func (l *influxLine) end(timestamp time.Time) []byte {
+	fmt.Fprintf(&l.buf, " %d\n", timestamp.UnixNano())
+	return l.buf.Bytes()
+}
+
+// influxTags are the tags of a target merged with the custom labels.
+func influxTags(probe string, target string, port int, labels map[string]string) map[string]string {
+	tags := map[string]string{}
+	for key, value := range labels {
+		tags[key] = value
+	}
+	tags["probe"] = probe
+	tags["target"] = target
+	tags["port"] = strconv.Itoa(port)
+	return tags
+}
+
+// InfluxCallLine formats a call in line protocol (measurement `moreping_call`).
+func InfluxCallLine(result ProbeResult, labels map[string]string) []byte {
+	line := newInfluxLine(InfluxCallMeasurement, influxTags(result.Probe, result.IpAddress, result.Port, labels))
+	line.bool("success", result.Success)
+	if result.Success {
+		line.float("latency_ms", milliseconds(result.Latency))
+	} else {
+		line.string("message", result.Message)
+	}
+	return line.end(result.Timestamp)
+}
+
+// InfluxBatchLine formats the stats of a batch in line protocol (measurement `moreping_batch`).
+func InfluxBatchLine(batch ProbeBatch, labels map[string]string) []byte {
+	line := newInfluxLine(InfluxBatchMeasurement, influxTags(batch.Probe, batch.IpAddress, batch.Port, labels))
+	line.int("experiments", batch.Experiments)
+	line.float("loss_ratio", float64(batch.PctPcktLoss))
+	line.float("avg_latency_ms", milliseconds(batch.AvgLatency))
+	if len(batch.Latencies) > 0 {
+		line.float("min_latency_ms", milliseconds(batch.MinLatency))
+		line.float("max_latency_ms", milliseconds(batch.MaxLatency))
+	}
+	failures := 0
+	for _, count := range batch.Failures {
+		failures += count
+	}
+	line.int("failures", failures)
+	return line.end(batch.Timestamp)
+}
+
+type influxWriterSink struct {
+	mutex  sync.Mutex
+	w      io.Writer
+	labels map[string]string
+}
+
+// NewInfluxWriterSink creates a sink writing every call and batch in line protocol
+// straight to a writer (e.g. a file to be imported later), tagged with the custom labels.
+func NewInfluxWriterSink(w io.Writer, labels map[string]string) ResultSink {
+	return &influxWriterSink{w: w, labels: labels}
+}
+
+func (i *influxWriterSink) WriteResult(result ProbeResult) error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	_, err := i.w.Write(InfluxCallLine(result, i.labels))
+	return err
+}
+
+func (i *influxWriterSink) WriteBatch(batch ProbeBatch) error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	_, err := i.w.Write(InfluxBatchLine(batch, i.labels))
+	return err
+}
+
+// InfluxOptions holds the configuration of the InfluxDB HTTP sink.
+// The zero values are replaced by the defaults.
+type InfluxOptions struct {
+	Labels        map[string]string // the custom tags added to every line
+	Token         string            // sent as "Authorization: Token <token>" (InfluxDB 2.x)
+	BatchSize     int               // the number of lines triggering a write (default 500)
+	FlushInterval time.Duration     // the maximum time the lines wait before a write (default 10s)
+	MaxBuffer     int               // the lines kept while the endpoint is down, the oldest are dropped (default 10000)
+	Timeout       time.Duration     // the timeout of each write (default 5s)
+}
+
+// InfluxSink is a result sink writing the calls and the batches in line protocol to an
+// InfluxDB compatible write endpoint, in batches. The lines are kept and retried at the
+// next flush when the endpoint is down (or answers with a server error).
+type InfluxSink struct {
+	mutex   sync.Mutex
+	url     string
+	options InfluxOptions
+	client  *http.Client
+	lines   [][]byte
+	dropped int
+	full    chan struct{}
+	quit    chan struct{}
+	done    chan struct{}
+}
+
+// NewInfluxSink creates a new sink for the write endpoint, e.g.
+// http://localhost:8086/write?db=moreping (1.x) or
+// http://localhost:8086/api/v2/write?org=my-org&bucket=moreping (2.x).
+// Close it to flush the remaining lines.
+func NewInfluxSink(url string, options InfluxOptions) *InfluxSink {
+	if options.BatchSize <= 0 {
+		options.BatchSize = 500
+	}
+	if options.FlushInterval <= 0 {
+		options.FlushInterval = 10 * time.Second
+	}
+	if options.MaxBuffer <= 0 {
+		options.MaxBuffer = 10000
+	}
+	if options.MaxBuffer < options.BatchSize {
+		options.MaxBuffer = options.BatchSize
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = 5 * time.Second
+	}
+	sink := &InfluxSink{
+		url:     url,
+		options: options,
+		client:  &http.Client{Timeout: options.Timeout},
+		full:    make(chan struct{}, 1),
+		quit:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go sink.run()
+	return sink
+}
+
+// WriteResult buffers the line of the call.
+func (i *InfluxSink) WriteResult(result ProbeResult) error {
+	i.buffer(InfluxCallLine(result, i.options.Labels))
+	return nil
+}
+
+// WriteBatch buffers the line of the batch.
+func (i *InfluxSink) WriteBatch(batch ProbeBatch) error {
+	i.buffer(InfluxBatchLine(batch, i.options.Labels))
+	return nil
+}
+
+// Close writes the remaining lines (once) and stops the sink.
+func (i *InfluxSink) Close() error {
+	close(i.quit)
+	<-i.done
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	if len(i.lines) > 0 {
+		return fmt.Errorf("unable to write %d lines to %s", len(i.lines), i.url)
+	}
+	return nil
+}
+
+func (i *InfluxSink) buffer(line []byte) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.lines = append(i.lines, line)
+	i.trim()
+	if len(i.lines) >= i.options.BatchSize {
+		select {
+		case i.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// trim drops the oldest lines beyond the maximum buffer size.
+func (i *InfluxSink) trim() {
+	if excess := len(i.lines) - i.options.MaxBuffer; excess > 0 {
+		i.lines = i.lines[excess:]
+		i.dropped += excess
+	}
+}
+
+func (i *InfluxSink) run() {
+	defer close(i.done)
+	ticker := time.NewTicker(i.options.FlushInterval)
+	defer ticker.Stop()
+	failing := false
+	for {
+		select {
+		case <-ticker.C:
+			failing = !i.flush()
+		case <-i.full:
+			// while the endpoint is down only retry at every interval
+			if !failing {
+				failing = !i.flush()
+			}
+		case <-i.quit:
+			i.flush()
+			return
+		}
+	}
+}
+
+// flush writes the buffered lines, putting them back in front of the buffer
+// when the write can be retried (in which case it returns false).
+func (i *InfluxSink) flush() bool {
+	i.mutex.Lock()
+	lines := i.lines
+	i.lines = nil
+	if i.dropped > 0 {
+		Logger.Printf("Dropped %d InfluxDB lines (buffer full)\n", i.dropped)
+		i.dropped = 0
+	}
+	i.mutex.Unlock()
+
+	for len(lines) > 0 {
+		size := i.options.BatchSize
+		if size > len(lines) {
+			size = len(lines)
+		}
+		retry, err := i.write(lines[:size])
+		if err != nil {
+			Logger.Printf("Unable to write %d lines to InfluxDB: %s\n", size, err)
+			if retry {
+				i.mutex.Lock()
+				i.lines = append(lines, i.lines...)
+				i.trim()
+				i.mutex.Unlock()
+				return false
+			}
+		}
+		lines = lines[size:]
+	}
+	return true
+}
+
+// write posts the lines, telling whether a failure can be retried
+// (the client errors are not, as the same lines would fail again).
+func (i *InfluxSink) write(lines [][]byte) (bool, error) {
+	request, err := http.NewRequest("POST", i.url, bytes.NewReader(bytes.Join(lines, nil)))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if i.options.Token != "" {
+		request.Header.Set("Authorization", "Token "+i.options.Token)
+	}
+	response, err := i.client.Do(request)
+	if err != nil {
+		return true, err
+	}
+	defer response.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(response.Body, 512))
+	if response.StatusCode/100 == 2 {
+		return false, nil
+	}
+	retry := response.StatusCode >= 500 || response.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("%s: %s", response.Status, strings.TrimSpace(string(body)))
+}
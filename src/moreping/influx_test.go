@@ -0,0 +1,110 @@
+package moreping_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/tappoz/moreping/src/moreping"
+)
+
+// influxStandIn is a write endpoint failing with the given status codes first
+type influxStandIn struct {
+	mutex    sync.Mutex
+	failures []int
+	lines    []string
+	headers  []http.Header
+}
+
+func (i *influxStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	body, _ := ioutil.ReadAll(r.Body)
+	i.headers = append(i.headers, r.Header)
+	if len(i.failures) > 0 {
+		status := i.failures[0]
+		i.failures = i.failures[1:]
+		http.Error(w, "stand-in failure", status)
+		return
+	}
+	i.lines = append(i.lines, strings.Split(strings.TrimSpace(string(body)), "\n")...)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (i *influxStandIn) received() []string {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	return append([]string{}, i.lines...)
+}
+
+var _ = Describe("InfluxDB", func() {
+
+	timestamp := time.Unix(1500000000, 0)
+	labels := map[string]string{"site": "london dc", "rack": ""}
+	call := moreping.ProbeResult{Probe: "tcp", IpAddress: "10.0.0.1", Port: 22, Timestamp: timestamp, Success: true, Latency: 1500 * time.Microsecond}
+	failedCall := moreping.ProbeResult{Probe: "tcp", IpAddress: "10.0.0.1", Port: 22, Timestamp: timestamp, Message: `said "no"`}
+	batch := moreping.ProbeBatch{Probe: "icmp", IpAddress: "10.0.0.2", Timestamp: timestamp, Experiments: 2, PctPcktLoss: 0.5,
+		AvgLatency: 5 * time.Millisecond, MinLatency: time.Millisecond, MaxLatency: time.Millisecond,
+		Latencies: []time.Duration{time.Millisecond}, Failures: map[string]int{"timeout": 1}}
+
+	It("should format the calls and the batches in line protocol", func() {
+		output := &bytes.Buffer{}
+		sink := moreping.NewInfluxWriterSink(output, labels)
+
+		Expect(sink.WriteResult(call)).To(Succeed())
+		Expect(sink.WriteResult(failedCall)).To(Succeed())
+		Expect(sink.WriteBatch(batch)).To(Succeed())
+
+		Expect(output.String()).To(Equal(
+			`moreping_call,port=22,probe=tcp,site=london\ dc,target=10.0.0.1 success=true,latency_ms=1.5 1500000000000000000` + "\n" +
+				`moreping_call,port=22,probe=tcp,site=london\ dc,target=10.0.0.1 success=false,message="said \"no\"" 1500000000000000000` + "\n" +
+				`moreping_batch,port=0,probe=icmp,site=london\ dc,target=10.0.0.2 experiments=2i,loss_ratio=0.5,avg_latency_ms=5,min_latency_ms=1,max_latency_ms=1,failures=1i 1500000000000000000` + "\n"))
+	})
+
+	It("should write the lines in batches to the endpoint", func() {
+		standIn := &influxStandIn{}
+		server := httptest.NewServer(standIn)
+		defer server.Close()
+		sink := moreping.NewInfluxSink(server.URL+"/write?db=moreping", moreping.InfluxOptions{BatchSize: 2, FlushInterval: time.Hour, Token: "secret"})
+
+		sink.WriteResult(call)
+		Expect(standIn.received()).To(BeEmpty())
+		sink.WriteBatch(batch)
+		Eventually(standIn.received).Should(HaveLen(2))
+		sink.WriteResult(failedCall)
+		Expect(sink.Close()).To(Succeed())
+
+		Expect(standIn.received()).To(HaveLen(3))
+		Expect(standIn.headers[0].Get("Authorization")).To(Equal("Token secret"))
+	})
+
+	It("should keep the lines while the endpoint is down and drop the invalid ones", func() {
+		standIn := &influxStandIn{failures: []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusBadRequest}}
+		server := httptest.NewServer(standIn)
+		defer server.Close()
+		sink := moreping.NewInfluxSink(server.URL, moreping.InfluxOptions{BatchSize: 10, FlushInterval: 20 * time.Millisecond})
+
+		sink.WriteResult(call)
+		sink.WriteResult(failedCall)
+		// two failures (retried) then a bad request (dropped)
+		Eventually(func() int { standIn.mutex.Lock(); defer standIn.mutex.Unlock(); return len(standIn.headers) }).Should(Equal(3))
+		sink.WriteBatch(batch)
+		Expect(sink.Close()).To(Succeed())
+
+		Expect(standIn.received()).To(HaveLen(1))
+		Expect(standIn.received()[0]).To(HavePrefix("moreping_batch,"))
+	})
+
+	It("should report the lines which could not be written when closed", func() {
+		sink := moreping.NewInfluxSink("http://127.0.0.1:1/write", moreping.InfluxOptions{Timeout: 100 * time.Millisecond})
+		sink.WriteResult(call)
+
+		Expect(sink.Close()).To(MatchError(ContainSubstring("unable to write 1 lines")))
+	})
+})
@@ -0,0 +1,229 @@
+package moreping
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// JSONSchemaVersion is the version of the JSON records, increased on breaking changes only
+// (new fields can be added within the same version).
+const JSONSchemaVersion = 1
+
+// The kinds of the JSON records.
+const (
+	JSONCallKind        = "call"
+	JSONBatchKind       = "batch"
+	JSONStateChangeKind = "state_change"
+)
+
+// JSONCall is the JSON record of a single call. The latency is null for the failed calls.
+type JSONCall struct {
+	Version   int               `json:"version"`
+	Kind      string            `json:"kind"`
+	Timestamp time.Time         `json:"timestamp"`
+	Probe     string            `json:"probe"`
+	Target    string            `json:"target"`
+	Port      int               `json:"port"`
+	Success   bool              `json:"success"`
+	Message   string            `json:"message"`
+	LatencyMs *float64          `json:"latency_ms"`
+	Details   map[string]string `json:"details"`
+}
+
+// JSONBatch is the JSON record of the stats of a batch of calls.
+// The latencies are those of the successful calls only (null when there are none),
+// while the average includes the timeouts as the other outputs.
+type JSONBatch struct {
+	Version      int            `json:"version"`
+	Kind         string         `json:"kind"`
+	Timestamp    time.Time      `json:"timestamp"`
+	Probe        string         `json:"probe"`
+	Target       string         `json:"target"`
+	Port         int            `json:"port"`
+	Experiments  int            `json:"experiments"`
+	LossRatio    float32        `json:"loss_ratio"`
+	AvgLatencyMs float64        `json:"avg_latency_ms"`
+	MinLatencyMs *float64       `json:"min_latency_ms"`
+	MaxLatencyMs *float64       `json:"max_latency_ms"`
+	LatenciesMs  []float64      `json:"latencies_ms"`
+	Failures     map[string]int `json:"failures"`
+}
+
+// JSONStateChange is the JSON record of the transition of a target between two states,
+// with its recent batches (the last one caused the transition) and their failure reasons.
+type JSONStateChange struct {
+	Version       int            `json:"version"`
+	Kind          string         `json:"kind"`
+	Timestamp     time.Time      `json:"timestamp"`
+	Probe         string         `json:"probe"`
+	Target        string         `json:"target"`
+	Port          int            `json:"port"`
+	PreviousState string         `json:"previous_state"`
+	State         string         `json:"state"`
+	Since         *time.Time     `json:"since"`
+	Batch         JSONBatch      `json:"batch"`
+	Recent        []JSONBatch    `json:"recent"`
+	Failures      map[string]int `json:"failures"`
+}
+
+// NewJSONCall converts a call to its JSON record.
+func NewJSONCall(result ProbeResult) JSONCall {
+	call := JSONCall{
+		Version:   JSONSchemaVersion,
+		Kind:      JSONCallKind,
+		Timestamp: result.Timestamp,
+		Probe:     result.Probe,
+		Target:    result.IpAddress,
+		Port:      result.Port,
+		Success:   result.Success,
+		Message:   result.Message,
+		Details:   result.Details,
+	}
+	if call.Details == nil {
+		call.Details = map[string]string{}
+	}
+	if result.Success {
+		latency := milliseconds(result.Latency)
+		call.LatencyMs = &latency
+	}
+	return call
+}
+
+// NewJSONBatch converts the stats of a batch to its JSON record.
+func NewJSONBatch(batch ProbeBatch) JSONBatch {
+	record := JSONBatch{
+		Version:      JSONSchemaVersion,
+		Kind:         JSONBatchKind,
+		Timestamp:    batch.Timestamp,
+		Probe:        batch.Probe,
+		Target:       batch.IpAddress,
+		Port:         batch.Port,
+		Experiments:  batch.Experiments,
+		LossRatio:    batch.PctPcktLoss,
+		AvgLatencyMs: milliseconds(batch.AvgLatency),
+		LatenciesMs:  []float64{},
+		Failures:     batch.Failures,
+	}
+	if record.Failures == nil {
+		record.Failures = map[string]int{}
+	}
+	for _, latency := range batch.Latencies {
+		record.LatenciesMs = append(record.LatenciesMs, milliseconds(latency))
+	}
+	if len(batch.Latencies) > 0 {
+		minLatency, maxLatency := milliseconds(batch.MinLatency), milliseconds(batch.MaxLatency)
+		record.MinLatencyMs, record.MaxLatencyMs = &minLatency, &maxLatency
+	}
+	return record
+}
+
+// NewJSONStateChange converts a state change to its JSON record.
+func NewJSONStateChange(change StateChange) JSONStateChange {
+	last := change.Last()
+	record := JSONStateChange{
+		Version:       JSONSchemaVersion,
+		Kind:          JSONStateChangeKind,
+		Timestamp:     last.Timestamp,
+		Probe:         change.Probe,
+		Target:        change.IpAddress,
+		Port:          change.Port,
+		PreviousState: string(change.Previous),
+		State:         string(change.State),
+		Batch:         NewJSONBatch(last),
+		Recent:        []JSONBatch{},
+		Failures:      change.Failures(),
+	}
+	if !change.Since.IsZero() {
+		since := change.Since
+		record.Since = &since
+	}
+	for _, batch := range change.Batches {
+		record.Recent = append(record.Recent, NewJSONBatch(batch))
+	}
+	return record
+}
+
+// ProbeResult converts the JSON record back to a call.
+func (j JSONCall) ProbeResult() ProbeResult {
+	result := ProbeResult{
+		Probe:     j.Probe,
+		IpAddress: j.Target,
+		Port:      j.Port,
+		Timestamp: j.Timestamp,
+		Success:   j.Success,
+		Message:   j.Message,
+		Latency:   InfiniteLatency,
+		Details:   j.Details,
+	}
+	if j.LatencyMs != nil {
+		result.Latency = fromMilliseconds(*j.LatencyMs)
+	}
+	return result
+}
+
+// ProbeBatch converts the JSON record back to the stats of a batch.
+func (j JSONBatch) ProbeBatch() ProbeBatch {
+	batch := ProbeBatch{
+		Probe:       j.Probe,
+		IpAddress:   j.Target,
+		Port:        j.Port,
+		Timestamp:   j.Timestamp,
+		Experiments: j.Experiments,
+		PctPcktLoss: j.LossRatio,
+		AvgLatency:  fromMilliseconds(j.AvgLatencyMs),
+		Failures:    j.Failures,
+	}
+	for _, latency := range j.LatenciesMs {
+		batch.Latencies = append(batch.Latencies, fromMilliseconds(latency))
+	}
+	if j.MinLatencyMs != nil && j.MaxLatencyMs != nil {
+		batch.MinLatency, batch.MaxLatency = fromMilliseconds(*j.MinLatencyMs), fromMilliseconds(*j.MaxLatencyMs)
+	}
+	return batch
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// fromMilliseconds rounds the milliseconds to the closest nanosecond.
+func fromMilliseconds(ms float64) time.Duration {
+	return time.Duration(ms*float64(time.Millisecond) + 0.5)
+}
+
+type jsonSink struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+	calls   bool
+}
+
+// NewNDJSONSink creates a sink writing every call and every batch as a JSON record
+// on its own line (newline delimited JSON).
+func NewNDJSONSink(w io.Writer) ResultSink {
+	return &jsonSink{encoder: json.NewEncoder(w), calls: true}
+}
+
+// NewPrettyJSONSink creates a sink writing the stats of every batch as an indented
+// JSON record (the single calls are not written).
+func NewPrettyJSONSink(w io.Writer) ResultSink {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return &jsonSink{encoder: encoder}
+}
+
+func (j *jsonSink) WriteResult(result ProbeResult) error {
+	if !j.calls {
+		return nil
+	}
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.encoder.Encode(NewJSONCall(result))
+}
+
+func (j *jsonSink) WriteBatch(batch ProbeBatch) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.encoder.Encode(NewJSONBatch(batch))
+}
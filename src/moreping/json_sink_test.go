@@ -0,0 +1,68 @@
+package moreping_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/tappoz/moreping/src/moreping"
+)
+
+var _ = Describe("JSON output", func() {
+
+	timestamp := time.Date(2017, time.July, 14, 2, 40, 0, 0, time.UTC)
+	target := moreping.Target{IpAddress: "10.0.0.1", Port: 22}
+
+	It("should write every call and every batch as a line of NDJSON", func() {
+		output := &bytes.Buffer{}
+		sink := moreping.NewNDJSONSink(output)
+		prober := &fakeProber{latencies: []time.Duration{1500 * time.Microsecond, 0}}
+		batch := moreping.ProbeBatchIP(prober, target, 2, sink)
+		batch.Timestamp = timestamp
+		Expect(sink.WriteBatch(batch)).To(Succeed())
+
+		records := []map[string]interface{}{}
+		scanner := bufio.NewScanner(output)
+		for scanner.Scan() {
+			record := map[string]interface{}{}
+			Expect(json.Unmarshal(scanner.Bytes(), &record)).To(Succeed())
+			records = append(records, record)
+		}
+
+		Expect(records).To(HaveLen(3))
+		Expect(records[0]).To(HaveKeyWithValue("kind", "call"))
+		Expect(records[0]).To(HaveKeyWithValue("version", 1.0))
+		Expect(records[0]).To(HaveKeyWithValue("probe", "fake"))
+		Expect(records[0]).To(HaveKeyWithValue("target", "10.0.0.1"))
+		Expect(records[0]).To(HaveKeyWithValue("port", 22.0))
+		Expect(records[0]).To(HaveKeyWithValue("success", true))
+		Expect(records[0]).To(HaveKeyWithValue("latency_ms", 1.5))
+		Expect(records[1]).To(HaveKeyWithValue("success", false))
+		Expect(records[1]).To(HaveKeyWithValue("latency_ms", BeNil()))
+		Expect(records[1]).To(HaveKeyWithValue("message", "fake failure"))
+		Expect(records[2]).To(HaveKeyWithValue("kind", "batch"))
+		Expect(records[2]).To(HaveKeyWithValue("timestamp", "2017-07-14T02:40:00Z"))
+		Expect(records[2]).To(HaveKeyWithValue("experiments", 2.0))
+		Expect(records[2]).To(HaveKeyWithValue("loss_ratio", 0.5))
+		Expect(records[2]).To(HaveKeyWithValue("min_latency_ms", 1.5))
+		Expect(records[2]).To(HaveKeyWithValue("latencies_ms", []interface{}{1.5}))
+		Expect(records[2]).To(HaveKeyWithValue("failures", map[string]interface{}{"fake failure": 1.0}))
+	})
+
+	It("should write the batches only as indented JSON", func() {
+		output := &bytes.Buffer{}
+		sink := moreping.NewPrettyJSONSink(output)
+		Expect(sink.WriteResult(moreping.ProbeResult{Probe: "fake", IpAddress: "10.0.0.1"})).To(Succeed())
+		Expect(sink.WriteBatch(moreping.ProbeBatch{Probe: "fake", IpAddress: "10.0.0.1", Timestamp: timestamp, Experiments: 3, PctPcktLoss: 1})).To(Succeed())
+
+		Expect(output.String()).To(HavePrefix("{\n  \"version\": 1,\n  \"kind\": \"batch\",\n"))
+		record := moreping.JSONBatch{}
+		Expect(json.Unmarshal(output.Bytes(), &record)).To(Succeed())
+		Expect(record.LossRatio).To(Equal(float32(1)))
+		Expect(record.MinLatencyMs).To(BeNil())
+		Expect(record.LatenciesMs).To(BeEmpty())
+	})
+})
@@ -10,6 +10,10 @@ type IcmpCall struct {
 	Success   bool
 	Message   string
 	Latency   time.Duration
+	SourceIP  string // the local IP address the call left from (when known)
+	TTL       int    // the TTL of the reply (when known)
+	Size      int    // the size of the ICMP reply in bytes (when known)
+	From      string // the router which answered with an ICMP error instead of the target
 }
 
 // IcmpBatch models a batch of ICMP calls to a given IP address
@@ -28,7 +32,16 @@ type TcpCall struct {
 	IpAddress string
 	TcpPort   int // TODO how about nil for numbers?
 	Success   bool
+	Message   string
 	Latency   time.Duration
+	SourceIP  string // the local IP address the dial left from (when known)
+	// this makes sense only when dialing through a proxy (the latency is end-to-end)
+	ProxyLatency time.Duration // time to connect to the proxy itself
+	// these make sense only when a send/expect script is run after connecting
+	BannerLatency time.Duration // time to the first bytes sent by the server
+	FailedStep    int           // 1-based index of the failed step, 0 if none
+	// this makes sense only for the SYN probes of a closed port
+	RstLatency time.Duration // time to the RST of the target
 }
 
 // TcpBatch models a batch of TCP dials to an IP address and a TCP port
@@ -42,3 +55,107 @@ type TcpBatch struct {
 	// with a multi-modal behaviour
 	AvgLatency time.Duration
 }
+
+// HopCall models a single TTL limited ICMP call towards a target IP address,
+// answered either by a router on the path or by the target itself
+type HopCall struct {
+	Hop       int
+	IpAddress string // the address of the hop that answered
+	Success   bool
+	Latency   time.Duration
+}
+
+// HopStats models the statistics of a hop on the path to a target IP address
+// as they evolve over the rounds of calls (mtr-like)
+type HopStats struct {
+	Hop         int
+	IpAddress   string
+	Sent        int
+	Received    int
+	PctPcktLoss float32
+	Last        time.Duration
+	AvgLatency  time.Duration
+	Best        time.Duration
+	Worst       time.Duration
+	StdDev      time.Duration
+}
+
+// FragNeeded models an ICMP "fragmentation needed" message received while
+// discovering the path MTU (an empty IP address means the local host)
+type FragNeeded struct {
+	IpAddress  string
+	NextHopMTU int // 0 if not reported
+}
+
+// PathMTU models the outcome of a path MTU discovery to a given IP address
+type PathMTU struct {
+	IpAddress  string
+	PathMTU    int // the largest IP packet (in bytes) getting through without fragmentation
+	Probes     int
+	FragNeeded []FragNeeded
+}
+
+// HandshakeCall models a single protocol handshake (e.g. Redis PING)
+// with a server listening on an IP address and a TCP port
+type HandshakeCall struct {
+	IpAddress        string
+	TcpPort          int
+	Protocol         string
+	Success          bool
+	Message          string // the client side error, if any
+	ServerError      string // the error reported by the server, if any
+	ServerVersion    string // only when advertised by the server (e.g. MySQL)
+	ConnectLatency   time.Duration
+	HandshakeLatency time.Duration
+	Latency          time.Duration // connect plus handshake
+}
+
+// Target models what a probe is run against: an IP address (or host name)
+// and a port, which is 0 for the probes not based on TCP (e.g. ICMP)
+type Target struct {
+	IpAddress string
+	Port      int
+	// Source overrides the source options of the TCP and ICMP probers for this target
+	Source SourceOptions
+	// Proxy overrides the proxy of the TCP prober for this target
+	Proxy string
+}
+
+// ProbeResult models a single call of any probe type (generic envelope)
+type ProbeResult struct {
+	Probe     string
+	IpAddress string
+	Port      int
+	Timestamp time.Time
+	Success   bool
+	Message   string
+	Latency   time.Duration
+	Details   map[string]string // probe specific fields (e.g. the server version)
+}
+
+// ProbeBatch models a batch of calls of any probe type to a target (generic envelope)
+type ProbeBatch struct {
+	Probe       string
+	IpAddress   string
+	Port        int
+	Timestamp   time.Time
+	Experiments int
+	PctPcktLoss float32
+	// as for the other batches this makes sense only if % of packet loss is close to 0.0
+	AvgLatency time.Duration
+	// these are calculated on the successful calls only
+	MinLatency time.Duration
+	MaxLatency time.Duration
+	Latencies  []time.Duration
+	Failures   map[string]int // how many times each failure reason occurred (the end of the messages)
+}
+
+// PortScan models the state of a TCP port of an IP address as classified by a scan
+type PortScan struct {
+	IpAddress string
+	TcpPort   int
+	State     PortState
+	Service   string // the well known service name, if any
+	Latency   time.Duration
+	Message   string
+}
@@ -98,6 +98,48 @@ var _ = Describe("NetDialers", func() {
 		})
 	})
 
+	Describe("ICMP pinger with packet options", func() {
+
+		icmpTimeout := 2 * time.Second
+		icmpChan := make(chan moreping.IcmpCall)
+		localhostIP := "127.0.0.1"
+
+		It("should synchronously ping localhost with custom size, TTL, TOS and payload pattern", func() {
+			options := moreping.IcmpOptions{Size: 1400, TTL: 3, TOS: moreping.DSCP(46), Pattern: []byte{0xff, 0x00}}
+			icmpPinger := moreping.NewIcmpPingerWithOptions(icmpTimeout, options, icmpChan)
+
+			go icmpPinger.PingIP(localhostIP)
+			icmpCallMsg := <-icmpChan
+
+			Expect(icmpCallMsg.IpAddress).To(Equal(localhostIP))
+			Expect(icmpCallMsg.Latency).Should(BeNumerically("<=", icmpTimeout))
+			Expect(icmpCallMsg.Success).To(Equal(true))
+		})
+
+		It("should return an unsuccessful ICMP message when the packet options are invalid", func() {
+			options := moreping.IcmpOptions{TTL: 300}
+			icmpPinger := moreping.NewIcmpPingerWithOptions(icmpTimeout, options, icmpChan)
+
+			go icmpPinger.PingIP(localhostIP)
+			icmpCallMsg := <-icmpChan
+
+			Expect(icmpCallMsg.Message).To(Equal("invalid TTL: 300"))
+			Expect(icmpCallMsg.Latency).Should(Equal(moreping.InfiniteLatency))
+			Expect(icmpCallMsg.Success).To(Equal(false))
+		})
+
+		It("should synchronously perform a batch of ping calls to localhost with custom packet options", func() {
+			batchSize := 5
+			icmpBatchPinger := moreping.NewIcmpBatchPingerWithOptions(icmpTimeout, moreping.IcmpOptions{Size: 1000}, make(chan moreping.IcmpBatch))
+			icmpBatch := icmpBatchPinger.PingBatchIP(localhostIP, batchSize)
+
+			Expect(icmpBatch.IpAddress).To(Equal(localhostIP))
+			Expect(icmpBatch.Expertiments).To(Equal(batchSize))
+			Expect(icmpBatch.PctPcktLoss).To(Equal(float32(0.0)))
+			Expect(icmpBatch.AvgLatency).Should(BeNumerically("<=", icmpTimeout))
+		})
+	})
+
 	Describe("TCP dialer", func() {
 
 		tcpTimeout := 2 * time.Second
@@ -163,6 +205,41 @@ var _ = Describe("NetDialers", func() {
 		})
 	})
 
+	Describe("TCP SYN dialer", func() {
+
+		tcpTimeout := 1 * time.Second
+		localhostIP := "127.0.0.1"
+		synPinger := moreping.NewTCPPingerWithOptions(nil, tcpTimeout, moreping.TCPOptions{SynMode: true}, nil)
+
+		It("should measure the SYN to SYN-ACK time on an open port of localhost", func() {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			defer listener.Close()
+			tcpPort := listener.Addr().(*net.TCPAddr).Port
+
+			tcpCallMsg := synPinger.DialIP(localhostIP, tcpPort)
+
+			Expect(tcpCallMsg.IpAddress).To(Equal(localhostIP))
+			Expect(tcpCallMsg.TcpPort).To(Equal(tcpPort))
+			Expect(tcpCallMsg.Latency).Should(BeNumerically("<=", tcpTimeout))
+			Expect(tcpCallMsg.Success).To(Equal(true))
+		})
+
+		It("should give back an unsuccessful TCP call message when a closed port answers with a RST", func() {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			tcpPort := listener.Addr().(*net.TCPAddr).Port
+			listener.Close()
+
+			tcpCallMsg := synPinger.DialIP(localhostIP, tcpPort)
+
+			Expect(tcpCallMsg.Success).To(Equal(false))
+			Expect(tcpCallMsg.Latency).Should(Equal(moreping.InfiniteLatency))
+			Expect(tcpCallMsg.Message).To(Equal("port closed"))
+			Expect(tcpCallMsg.RstLatency).Should(BeNumerically(">", 0))
+		})
+	})
+
 	Describe("TCP batch dialer", func() {
 
 		tcpTimeout := 500 * time.Millisecond // trying to be strict on the timeout on Google
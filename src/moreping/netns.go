@@ -0,0 +1,60 @@
+package moreping
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// netnsDir is where `ip netns add <name>` mounts the named network namespaces.
+const netnsDir = "/var/run/netns"
+
+var errNetnsUnsupported = errors.New("network namespaces are only supported on Linux")
+
+// NetnsPath returns the path of a network namespace given its name (as in `ip netns`)
+// or a path as it is, e.g. /proc/<pid>/ns/net for the namespace of a container.
+func NetnsPath(namespace string) string {
+	if strings.Contains(namespace, "/") {
+		return namespace
+	}
+	return filepath.Join(netnsDir, namespace)
+}
+
+type netnsProber struct {
+	prober    Prober
+	namespace string
+}
+
+// NewNetnsProber wraps a prober so that its calls originate from a network namespace
+// (a name as in `ip netns` or a path as /proc/<pid>/ns/net, root user only).
+// The probes run on an OS thread locked into the namespace while the sockets are created.
+func NewNetnsProber(prober Prober, namespace string) Prober {
+	return &netnsProber{prober: prober, namespace: namespace}
+}
+
+func (p *netnsProber) Name() string {
+	return p.prober.Name()
+}
+
+func (p *netnsProber) Probe(target Target) ProbeResult {
+	var result ProbeResult
+	err := inNetns(NetnsPath(p.namespace), func() {
+		result = p.prober.Probe(target)
+	})
+	if err != nil {
+		result = ProbeResult{
+			Probe:     p.prober.Name(),
+			IpAddress: target.IpAddress,
+			Port:      target.Port,
+			Timestamp: time.Now(),
+			Message:   err.Error(),
+			Latency:   InfiniteLatency,
+		}
+	}
+	if result.Details == nil {
+		result.Details = map[string]string{}
+	}
+	result.Details["netns"] = p.namespace
+	return result
+}
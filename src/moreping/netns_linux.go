@@ -0,0 +1,49 @@
+package moreping
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// inNetns runs the function on an OS thread switched to the network namespace, then
+// switches the thread back. The sockets created meanwhile stay in the namespace.
+// The function must create its sockets in the calling goroutine (not in new ones).
+// When the switch back fails the error is returned and the thread is left locked on purpose,
+// so that the runtime terminates it (instead of reusing it) when the goroutine exits.
+func inNetns(path string, f func()) error {
+	runtime.LockOSThread()
+	current, err := os.Open(fmt.Sprintf("/proc/self/task/%d/ns/net", syscall.Gettid()))
+	if err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+	defer current.Close()
+	target, err := os.Open(path)
+	if err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("unable to open the network namespace: %s", err)
+	}
+	defer target.Close()
+
+	if err := setns(target.Fd()); err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+	f()
+	if err := setns(current.Fd()); err != nil {
+		return fmt.Errorf("unable to switch back from the network namespace: %s", err)
+	}
+	runtime.UnlockOSThread()
+	return nil
+}
+
+func setns(fd uintptr) error {
+	if _, _, errno := syscall.RawSyscall(unix.SYS_SETNS, fd, syscall.CLONE_NEWNET, 0); errno != 0 {
+		return os.NewSyscallError("setns", errno)
+	}
+	return nil
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+// +build !linux
+
+package moreping
+
+func inNetns(path string, f func()) error {
+	return errNetnsUnsupported
+}
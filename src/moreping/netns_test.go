@@ -0,0 +1,62 @@
+package moreping_test
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/tappoz/moreping/src/moreping"
+)
+
+var _ = Describe("Network namespaces", func() {
+
+	probeTimeout := 300 * time.Millisecond
+
+	It("should resolve the named namespaces and keep the paths", func() {
+		Expect(moreping.NetnsPath("blue")).To(Equal("/var/run/netns/blue"))
+		Expect(moreping.NetnsPath("/proc/42/ns/net")).To(Equal("/proc/42/ns/net"))
+	})
+
+	It("should report the namespaces which cannot be entered", func() {
+		prober, err := moreping.NewProber(moreping.TCPProbe, moreping.ProbeConfig{Timeout: probeTimeout, Netns: "moreping-missing"})
+		Expect(err).NotTo(HaveOccurred())
+
+		result := prober.Probe(moreping.Target{IpAddress: "127.0.0.1", Port: 22})
+
+		Expect(result.Success).To(Equal(false))
+		Expect(result.Message).To(ContainSubstring("network namespace"))
+		Expect(result.Details["netns"]).To(Equal("moreping-missing"))
+	})
+
+	It("should dial from inside the namespace (as root)", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		defer listener.Close()
+		port := listener.Addr().(*net.TCPAddr).Port
+		// a new namespace, where even the loopback interface is down
+		isolated := exec.Command("unshare", "--net", "sleep", "30")
+		if err := isolated.Start(); err != nil {
+			Skip("unable to create a network namespace: " + err.Error())
+		}
+		defer isolated.Process.Kill()
+		time.Sleep(100 * time.Millisecond)
+		namespace := fmt.Sprintf("/proc/%d/ns/net", isolated.Process.Pid)
+
+		hostProber, err := moreping.NewProber(moreping.TCPProbe, moreping.ProbeConfig{Timeout: probeTimeout, Netns: "/proc/self/ns/net"})
+		Expect(err).NotTo(HaveOccurred())
+		isolatedProber, err := moreping.NewProber(moreping.TCPProbe, moreping.ProbeConfig{Timeout: probeTimeout, Netns: namespace})
+		Expect(err).NotTo(HaveOccurred())
+		target := moreping.Target{IpAddress: "127.0.0.1", Port: port}
+
+		result := hostProber.Probe(target)
+		Expect(result.Success).To(Equal(true), result.Message)
+		result = isolatedProber.Probe(target)
+		Expect(result.Success).To(Equal(false))
+		Expect(result.Message).To(ContainSubstring("unreachable"))
+		// the thread has been switched back
+		Expect(hostProber.Probe(target).Success).To(Equal(true))
+	})
+})
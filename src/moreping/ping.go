@@ -0,0 +1,165 @@
+package moreping
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+// PingSession probes a single target once per interval, printing a line per call and the statistics
+// at the end in the format of the iputils ping, e.g.
+//
+//	PING example.com (93.184.216.34) 56(84) bytes of data.
+//	64 bytes from 93.184.216.34: icmp_seq=1 ttl=56 time=11.6 ms
+//	no answer yet for icmp_seq=2
+//
+//	--- example.com ping statistics ---
+//	2 packets transmitted, 1 received, 50% packet loss, time 1012ms
+//	rtt min/avg/max/mdev = 11.632/11.632/11.632/0.000 ms
+//
+// The other probe types (e.g. TCP) print similar lines, with the port and without the TTL.
+type PingSession struct {
+	w      io.Writer
+	prober Prober
+	host   string
+	target Target
+	size   int
+
+	transmitted int
+	received    int
+	errors      int
+	minLatency  time.Duration
+	maxLatency  time.Duration
+	sum         float64 // of the latencies in milliseconds
+	sumSquares  float64
+}
+
+// NewPingSession creates a session printing the calls of the prober to the target, named as the host
+// given on the command line. The size is the ICMP payload size printed in the header (56 if 0).
+func NewPingSession(w io.Writer, prober Prober, host string, target Target, size int) *PingSession {
+	if size <= 0 {
+		size = defaultIcmpPayloadSize
+	}
+	return &PingSession{w: w, prober: prober, host: host, target: target, size: size}
+}
+
+// Run prints the header, probes the target count times (until stopped if 0) waiting for the interval
+// between the start of two calls, then prints the statistics. It tells whether any call was successful.
+func (s *PingSession) Run(count int, interval time.Duration, stop <-chan struct{}) bool {
+	if s.prober.Name() == IcmpProbe {
+		fmt.Fprintf(s.w, "PING %s (%s) %d(%d) bytes of data.\n", s.host, s.target.IpAddress, s.size, s.size+ipv4HeaderSize+icmpHeaderSize)
+	} else {
+		fmt.Fprintf(s.w, "PING %s (%s) port %d over %s.\n", s.host, s.target.IpAddress, s.target.Port, s.prober.Name())
+	}
+	start := time.Now()
+	for seq := 1; count <= 0 || seq <= count; seq++ {
+		s.Print(seq, s.prober.Probe(s.target))
+		if seq == count {
+			break
+		}
+		select {
+		case <-time.After(start.Add(time.Duration(seq) * interval).Sub(time.Now())):
+		case <-stop:
+			s.Summary(time.Now().Sub(start))
+			return s.received > 0
+		}
+	}
+	s.Summary(time.Now().Sub(start))
+	return s.received > 0
+}
+
+// Print prints the line of a call, and accounts it in the statistics.
+func (s *PingSession) Print(seq int, result ProbeResult) {
+	s.transmitted++
+	seqName := "icmp_seq"
+	if result.Probe != IcmpProbe {
+		seqName = result.Probe + "_seq"
+	}
+	address := result.IpAddress
+	if result.Probe != IcmpProbe {
+		address = fmt.Sprintf("%s:%d", result.IpAddress, result.Port)
+	}
+
+	if result.Success {
+		s.received++
+		s.account(result.Latency)
+		if result.Probe == IcmpProbe {
+			size := s.size + icmpHeaderSize
+			if replySize := result.Details["reply_size"]; replySize != "" {
+				fmt.Sscanf(replySize, "%d", &size)
+			}
+			ttl := ""
+			if result.Details["ttl"] != "" {
+				ttl = " ttl=" + result.Details["ttl"]
+			}
+			fmt.Fprintf(s.w, "%d bytes from %s: %s=%d%s time=%s ms\n", size, address, seqName, seq, ttl, pingTime(result.Latency))
+		} else {
+			fmt.Fprintf(s.w, "connected to %s: %s=%d time=%s ms\n", address, seqName, seq, pingTime(result.Latency))
+		}
+		return
+	}
+
+	// an answer which is not the expected one (e.g. an ICMP error or a TCP reset) is an error, as for ping
+	if from := result.Details["from"]; from != "" {
+		s.errors++
+		fmt.Fprintf(s.w, "From %s %s=%d %s\n", from, seqName, seq, strings.TrimSuffix(result.Message, " from "+from))
+	} else if result.Details["port_state"] == string(PortClosed) {
+		s.errors++
+		fmt.Fprintf(s.w, "From %s %s=%d %s\n", address, seqName, seq, failureReason(result.Message))
+	} else {
+		fmt.Fprintf(s.w, "no answer yet for %s=%d\n", seqName, seq)
+	}
+}
+
+func (s *PingSession) account(latency time.Duration) {
+	if s.received == 1 || latency < s.minLatency {
+		s.minLatency = latency
+	}
+	if latency > s.maxLatency {
+		s.maxLatency = latency
+	}
+	ms := milliseconds(latency)
+	s.sum += ms
+	s.sumSquares += ms * ms
+}
+
+// Summary prints the statistics of the calls printed so far, as ping does on exit.
+func (s *PingSession) Summary(elapsed time.Duration) {
+	name := s.host
+	if s.prober.Name() != IcmpProbe {
+		name = fmt.Sprintf("%s:%d %s", s.host, s.target.Port, s.prober.Name())
+	}
+	fmt.Fprintf(s.w, "\n--- %s ping statistics ---\n", name)
+	errors := ""
+	if s.errors > 0 {
+		errors = fmt.Sprintf("+%d errors, ", s.errors)
+	}
+	loss := 0.0
+	if s.transmitted > 0 {
+		loss = 100 * float64(s.transmitted-s.received) / float64(s.transmitted)
+	}
+	fmt.Fprintf(s.w, "%d packets transmitted, %d received, %s%g%% packet loss, time %dms\n",
+		s.transmitted, s.received, errors, math.Floor(loss*1000)/1000, elapsed/time.Millisecond)
+	if s.received > 0 {
+		avg := s.sum / float64(s.received)
+		mdev := math.Sqrt(math.Max(0, s.sumSquares/float64(s.received)-avg*avg))
+		fmt.Fprintf(s.w, "rtt min/avg/max/mdev = %.3f/%.3f/%.3f/%.3f ms\n", milliseconds(s.minLatency), avg, milliseconds(s.maxLatency), mdev)
+	}
+}
+
+// pingTime formats a latency with the precision of ping: 3 significant digits at least.
+func pingTime(latency time.Duration) string {
+	ms := milliseconds(latency)
+	switch {
+	case ms >= 100:
+		return fmt.Sprintf("%.0f", ms)
+	case ms >= 10:
+		return fmt.Sprintf("%.1f", ms)
+	case ms >= 1:
+		return fmt.Sprintf("%.2f", ms)
+	default:
+		return fmt.Sprintf("%.3f", ms)
+	}
+}
@@ -0,0 +1,100 @@
+package moreping_test
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/tappoz/moreping/src/moreping"
+)
+
+// scriptedProber returns the given results in turn
+type scriptedProber struct {
+	name    string
+	results []moreping.ProbeResult
+	calls   int
+}
+
+func (s *scriptedProber) Name() string {
+	return s.name
+}
+
+func (s *scriptedProber) Probe(target moreping.Target) moreping.ProbeResult {
+	result := s.results[s.calls%len(s.results)]
+	s.calls++
+	return result
+}
+
+var _ = Describe("Ping output", func() {
+
+	icmpReply := func(latency time.Duration) moreping.ProbeResult {
+		return moreping.ProbeResult{Probe: "icmp", IpAddress: "10.0.0.1", Success: true, Latency: latency,
+			Details: map[string]string{"ttl": "57", "reply_size": "64"}}
+	}
+
+	It("should print the ICMP calls and statistics as ping does", func() {
+		prober := &scriptedProber{name: "icmp", results: []moreping.ProbeResult{
+			icmpReply(11632 * time.Microsecond),
+			{Probe: "icmp", IpAddress: "10.0.0.1", Message: "This ping call is on timeout", Latency: moreping.InfiniteLatency, Details: map[string]string{}},
+			{Probe: "icmp", IpAddress: "10.0.0.1", Message: "Destination unreachable (code 1) from 192.168.1.1", Latency: moreping.InfiniteLatency,
+				Details: map[string]string{"from": "192.168.1.1"}},
+			icmpReply(123456 * time.Microsecond),
+			icmpReply(450 * time.Microsecond),
+		}}
+		output := &bytes.Buffer{}
+		session := moreping.NewPingSession(output, prober, "example.com", moreping.Target{IpAddress: "10.0.0.1"}, 0)
+		Expect(session.Run(5, time.Millisecond, nil)).To(BeTrue())
+
+		lines := strings.Split(output.String(), "\n")
+		Expect(lines[:10]).To(Equal([]string{
+			"PING example.com (10.0.0.1) 56(84) bytes of data.",
+			"64 bytes from 10.0.0.1: icmp_seq=1 ttl=57 time=11.6 ms",
+			"no answer yet for icmp_seq=2",
+			"From 192.168.1.1 icmp_seq=3 Destination unreachable (code 1)",
+			"64 bytes from 10.0.0.1: icmp_seq=4 ttl=57 time=123 ms",
+			"64 bytes from 10.0.0.1: icmp_seq=5 ttl=57 time=0.450 ms",
+			"",
+			"--- example.com ping statistics ---",
+			lines[8],
+			"rtt min/avg/max/mdev = 0.450/45.179/123.456/55.538 ms",
+		}))
+		Expect(lines[8]).To(MatchRegexp(`^5 packets transmitted, 3 received, \+1 errors, 40% packet loss, time \d+ms$`))
+	})
+
+	It("should print the TCP calls and stop when asked", func() {
+		prober := &scriptedProber{name: "tcp", results: []moreping.ProbeResult{
+			{Probe: "tcp", IpAddress: "10.0.0.1", Port: 22, Success: true, Latency: 2500 * time.Microsecond},
+			{Probe: "tcp", IpAddress: "10.0.0.1", Port: 22, Message: "dial tcp 10.0.0.1:22: connect: connection refused",
+				Latency: moreping.InfiniteLatency, Details: map[string]string{"port_state": "closed"}},
+		}}
+		output := &bytes.Buffer{}
+		stop := make(chan struct{})
+		time.AfterFunc(150*time.Millisecond, func() { close(stop) })
+		session := moreping.NewPingSession(output, prober, "ssh.local", moreping.Target{IpAddress: "10.0.0.1", Port: 22}, 0)
+		Expect(session.Run(0, 100*time.Millisecond, stop)).To(BeTrue())
+
+		lines := strings.Split(output.String(), "\n")
+		Expect(lines[:5]).To(Equal([]string{
+			"PING ssh.local (10.0.0.1) port 22 over tcp.",
+			"connected to 10.0.0.1:22: tcp_seq=1 time=2.50 ms",
+			"From 10.0.0.1:22 tcp_seq=2 connection refused",
+			"",
+			"--- ssh.local:22 tcp ping statistics ---",
+		}))
+		Expect(lines[5]).To(HavePrefix("2 packets transmitted, 1 received, +1 errors, 50% packet loss"))
+		Expect(lines[6]).To(Equal("rtt min/avg/max/mdev = 2.500/2.500/2.500/0.000 ms"))
+	})
+
+	It("should tell when no call was successful", func() {
+		prober := &scriptedProber{name: "icmp", results: []moreping.ProbeResult{
+			{Probe: "icmp", IpAddress: "10.0.0.1", Message: "This ping call is on timeout", Details: map[string]string{}},
+		}}
+		output := &bytes.Buffer{}
+		Expect(moreping.NewPingSession(output, prober, "10.0.0.1", moreping.Target{IpAddress: "10.0.0.1"}, 100).Run(2, time.Millisecond, nil)).To(BeFalse())
+		Expect(output.String()).To(HavePrefix("PING 10.0.0.1 (10.0.0.1) 100(128) bytes of data.\n"))
+		Expect(output.String()).To(ContainSubstring("2 packets transmitted, 0 received, 100% packet loss"))
+		Expect(output.String()).NotTo(ContainSubstring("rtt"))
+	})
+})
@@ -0,0 +1,104 @@
+package moreping
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	ipv4HeaderSize = 20
+	icmpHeaderSize = 8
+	// minPathMTU is the smallest MTU every IPv4 link must support (RFC 791)
+	minPathMTU = 68
+	maxPathMTU = 65535
+)
+
+// DiscoverPathMTU looks for the largest IP packet reaching the target IP address
+// without being fragmented. It sends ICMP echo requests with the "don't fragment" bit set,
+// binary searching the packet size between the IPv4 minimum MTU and the given maximum.
+// Each size is tried a few times before assuming it is dropped (black holes do not send
+// back any ICMP message). The "fragmentation needed" messages are collected along the way.
+// As for the ICMP pinger, on Linux this must be run as root user.
+func DiscoverPathMTU(targetIP string, maxMTU int, timeout time.Duration) (PathMTU, error) {
+	pathMTU := PathMTU{IpAddress: targetIP}
+	if maxMTU > maxPathMTU {
+		maxMTU = maxPathMTU
+	}
+	if maxMTU < minPathMTU {
+		return pathMTU, fmt.Errorf("the maximum MTU must be at least %d bytes", minPathMTU)
+	}
+
+	socket, err := newEchoSocket()
+	if err != nil {
+		return pathMTU, err
+	}
+	defer socket.Close()
+
+	const attempts = 3
+	seq := 0
+	// fits tells whether a packet of the given size gets through, otherwise
+	// it gives back the next hop MTU eventually reported by a router
+	fits := func(mtu int) (bool, int, error) {
+		for i := 0; i < attempts; i++ {
+			seq++
+			pathMTU.Probes++
+			params := echoParams{size: mtu - ipv4HeaderSize - icmpHeaderSize, dontFragment: true}
+			outcome, err := socket.echo(targetIP, seq, params, timeout)
+			if err != nil {
+				return false, 0, err
+			}
+			switch {
+			case outcome.reached:
+				return true, 0, nil
+			case outcome.tooBig:
+				pathMTU.addFragNeeded("", 0)
+				return false, 0, nil
+			case outcome.unreachable && outcome.code == 4:
+				pathMTU.addFragNeeded(outcome.peer, outcome.nextHopMTU)
+				return false, outcome.nextHopMTU, nil
+			case outcome.unreachable:
+				return false, 0, fmt.Errorf("%s is unreachable (ICMP code %d from %s)", targetIP, outcome.code, outcome.peer)
+			}
+			// Logger.Printf("No answer for a packet of %d bytes to %s\n", mtu, targetIP)
+		}
+		return false, 0, nil
+	}
+
+	ok, _, err := fits(minPathMTU)
+	if err != nil {
+		return pathMTU, err
+	}
+	if !ok {
+		return pathMTU, fmt.Errorf("%s does not answer to ICMP echo requests", targetIP)
+	}
+	low, high := minPathMTU, maxMTU
+	for low < high {
+		mid := (low + high + 1) / 2
+		ok, nextHopMTU, err := fits(mid)
+		if err != nil {
+			return pathMTU, err
+		}
+		if ok {
+			low = mid
+			continue
+		}
+		high = mid - 1
+		// a router told us the MTU of the next link, no need to search above it
+		if nextHopMTU >= low && nextHopMTU < high {
+			high = nextHopMTU
+		}
+	}
+	pathMTU.PathMTU = low
+	Logger.Printf("The path MTU to %s is %d bytes (%d probes)\n", targetIP, pathMTU.PathMTU, pathMTU.Probes)
+	return pathMTU, nil
+}
+
+// addFragNeeded keeps track of the "fragmentation needed" messages, once per sender and MTU.
+func (p *PathMTU) addFragNeeded(ipAddress string, nextHopMTU int) {
+	for _, fragNeeded := range p.FragNeeded {
+		if fragNeeded.IpAddress == ipAddress && fragNeeded.NextHopMTU == nextHopMTU {
+			return
+		}
+	}
+	p.FragNeeded = append(p.FragNeeded, FragNeeded{IpAddress: ipAddress, NextHopMTU: nextHopMTU})
+}
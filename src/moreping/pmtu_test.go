@@ -0,0 +1,31 @@
+package moreping_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/tappoz/moreping/src/moreping"
+)
+
+var _ = Describe("Path MTU", func() {
+
+	pmtuTimeout := 1 * time.Second
+	localhostIP := "127.0.0.1"
+
+	It("should find the maximum MTU when every packet size gets through (localhost)", func() {
+		pathMTU, err := moreping.DiscoverPathMTU(localhostIP, 1500, pmtuTimeout)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pathMTU.IpAddress).To(Equal(localhostIP))
+		Expect(pathMTU.PathMTU).To(Equal(1500))
+		Expect(pathMTU.Probes).Should(BeNumerically(">", 1))
+		Expect(pathMTU.FragNeeded).To(BeEmpty())
+	})
+
+	It("should refuse a maximum MTU smaller than the IPv4 minimum MTU", func() {
+		_, err := moreping.DiscoverPathMTU(localhostIP, 42, pmtuTimeout)
+
+		Expect(err).To(HaveOccurred())
+	})
+})
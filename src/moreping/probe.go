@@ -0,0 +1,200 @@
+package moreping
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Prober is the common interface of all the probe types (TCP, ICMP, handshakes, ...),
+// so that the scheduling and the sinks work uniformly with any of them.
+type Prober interface {
+	Name() string
+	Probe(target Target) ProbeResult
+}
+
+// ProbeConfig holds the configuration of the probers created via the registry.
+// Each probe type picks the relevant fields.
+type ProbeConfig struct {
+	Timeout time.Duration
+	TCP     TCPOptions
+	ICMP    IcmpOptions
+	// Netns runs the probes from a network namespace (see NewNetnsProber)
+	Netns string
+}
+
+// ProberFactory creates a new prober given its configuration.
+type ProberFactory func(config ProbeConfig) (Prober, error)
+
+var (
+	probersMutex sync.RWMutex
+	probers      = map[string]ProberFactory{}
+)
+
+// RegisterProber makes a probe type available by name (e.g. from the command line).
+// As for `database/sql` it panics if the name is registered twice.
+func RegisterProber(name string, factory ProberFactory) {
+	probersMutex.Lock()
+	defer probersMutex.Unlock()
+	if factory == nil {
+		panic("moreping: RegisterProber factory is nil")
+	}
+	if _, dup := probers[name]; dup {
+		panic("moreping: RegisterProber called twice for " + name)
+	}
+	probers[name] = factory
+}
+
+// NewProber creates a new instance of a registered probe type.
+func NewProber(name string, config ProbeConfig) (Prober, error) {
+	probersMutex.RLock()
+	factory, ok := probers[name]
+	probersMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown probe type: %s (available: %v)", name, Probers())
+	}
+	prober, err := factory(config)
+	if err != nil || config.Netns == "" {
+		return prober, err
+	}
+	return NewNetnsProber(prober, config.Netns), nil
+}
+
+// Probers returns the sorted names of the registered probe types.
+func Probers() []string {
+	probersMutex.RLock()
+	defer probersMutex.RUnlock()
+	names := make([]string, 0, len(probers))
+	for name := range probers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Targets combines a list of IP addresses with a list of ports.
+// With no ports every IP address is combined with port 0 (e.g. for ICMP).
+func Targets(ips []string, ports []int) []Target {
+	targets := []Target{}
+	for _, ip := range ips {
+		if len(ports) == 0 {
+			targets = append(targets, Target{IpAddress: ip})
+			continue
+		}
+		for _, port := range ports {
+			targets = append(targets, Target{IpAddress: ip, Port: port})
+		}
+	}
+	return targets
+}
+
+// ProbeBatchIP performs a batch of calls to a target with any probe type,
+// each call is written to the sink (if any) as soon as it completes.
+// The calls and the batch are those of the target as given, e.g. a host name: the
+// address a call reports when it differs (e.g. the resolved IP address of a successful
+// ICMP call) is kept in the `resolved_ip` detail, so that the sinks see a single target.
+// The port is that of the target, unless it is 0 and the prober dialed its own default
+// port (e.g. 6379 for redis).
+func ProbeBatchIP(prober Prober, target Target, batchSize int, sink ResultSink) ProbeBatch {
+	results := make([]ProbeResult, 0, batchSize)
+	for i := 0; i < batchSize; i++ {
+		result := prober.Probe(target)
+		if result.IpAddress != target.IpAddress {
+			if result.Details == nil {
+				result.Details = map[string]string{}
+			}
+			if result.IpAddress != "" {
+				result.Details["resolved_ip"] = result.IpAddress
+			}
+			result.IpAddress = target.IpAddress
+		}
+		if result.Port == 0 {
+			result.Port = target.Port
+		}
+		if sink != nil {
+			if err := sink.WriteResult(result); err != nil {
+				Logger.Printf("Unable to write the %s result for %s: %s\n", result.Probe, result.IpAddress, err)
+			}
+		}
+		results = append(results, result)
+	}
+	batch := NewProbeBatch(results)
+	batch.IpAddress = target.IpAddress
+	if target.Port != 0 {
+		batch.Port = target.Port
+	}
+	if batch.Probe == "" {
+		batch.Probe = prober.Name()
+	}
+	return batch
+}
+
+// NewProbeBatch calculates the stats of a batch of calls to the same target.
+func NewProbeBatch(results []ProbeResult) ProbeBatch {
+	batch := ProbeBatch{Experiments: len(results), Failures: map[string]int{}}
+	if len(results) == 0 {
+		return batch
+	}
+	batch.Probe = results[0].Probe
+	batch.IpAddress = results[0].IpAddress
+	batch.Port = results[0].Port
+	batch.Timestamp = results[0].Timestamp
+
+	avgLatency := float32(0)
+	unSuccessCount := 0
+	for idx, result := range results {
+		avgLatency = InPlaceAvg(avgLatency, float32(result.Latency), idx)
+		if !result.Success {
+			unSuccessCount++
+			batch.Failures[failureReason(result.Message)]++
+			continue
+		}
+		if len(batch.Latencies) == 0 || result.Latency < batch.MinLatency {
+			batch.MinLatency = result.Latency
+		}
+		if result.Latency > batch.MaxLatency {
+			batch.MaxLatency = result.Latency
+		}
+		batch.Latencies = append(batch.Latencies, result.Latency)
+	}
+	batch.AvgLatency = time.Duration(avgLatency)
+	batch.PctPcktLoss = float32(unSuccessCount) / float32(len(results))
+	return batch
+}
+
+// failureReason keeps the last part of an error message (e.g. "connection refused"
+// from "dial tcp 10.0.0.1:22: connect: connection refused"), without the address of
+// the router of an ICMP error, so that the failures of a batch have a few reasons.
+func failureReason(message string) string {
+	if idx := strings.LastIndex(message, ": "); idx >= 0 {
+		message = message[idx+2:]
+	}
+	if idx := strings.LastIndex(message, " from "); idx >= 0 && net.ParseIP(message[idx+6:]) != nil {
+		message = message[:idx]
+	}
+	if message == "" {
+		return "unknown"
+	}
+	return message
+}
+
+// ProbeBatchFunc is a "func" type that can be used to schedule batches of calls
+// with any probe type: at each run a batch is performed for every target (asynchronously)
+// and the calls and the batches are written to the sinks (one at a time). The funcs sharing
+// some sinks must be given the same NewMultiSink(...) of them, so that they do not write concurrently.
+func ProbeBatchFunc(prober Prober, targets []Target, batchSize int, sinks ...ResultSink) func() {
+	sink := NewMultiSink(sinks...)
+	return func() {
+		for _, target := range targets {
+			go func(target Target) {
+				batch := ProbeBatchIP(prober, target, batchSize, sink)
+				if err := sink.WriteBatch(batch); err != nil {
+					Logger.Printf("Unable to write the %s batch for %s: %s\n", batch.Probe, batch.IpAddress, err)
+				}
+			}(target)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package moreping_test
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/tappoz/moreping/src/moreping"
+)
+
+// fakeProber answers with the given latencies in a round robin fashion,
+// a 0 latency means an unsuccessful call
+type fakeProber struct {
+	mutex     sync.Mutex
+	latencies []time.Duration
+	calls     int
+}
+
+func (f *fakeProber) Name() string {
+	return "fake"
+}
+
+func (f *fakeProber) Probe(target moreping.Target) moreping.ProbeResult {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	latency := f.latencies[f.calls%len(f.latencies)]
+	f.calls++
+	result := moreping.ProbeResult{Probe: "fake", IpAddress: target.IpAddress, Port: target.Port, Timestamp: time.Now(), Success: latency > 0, Latency: latency}
+	if latency == 0 {
+		result.Message = "fake failure"
+		result.Latency = moreping.InfiniteLatency
+	}
+	return result
+}
+
+// recordingSink keeps in memory everything written to it
+type recordingSink struct {
+	results []moreping.ProbeResult
+	batches chan moreping.ProbeBatch
+}
+
+func (r *recordingSink) WriteResult(result moreping.ProbeResult) error {
+	r.results = append(r.results, result)
+	return nil
+}
+
+func (r *recordingSink) WriteBatch(batch moreping.ProbeBatch) error {
+	r.batches <- batch
+	return nil
+}
+
+// channelLogger sends the log messages to a channel
+type channelLogger chan string
+
+func (c channelLogger) Printf(format string, v ...interface{}) {
+	c <- fmt.Sprintf(format, v...)
+}
+
+var _ = Describe("Probe", func() {
+
+	Describe("Registry", func() {
+
+		It("should provide the built-in probe types", func() {
+			Expect(moreping.Probers()).To(ContainElement(moreping.TCPProbe))
+			Expect(moreping.Probers()).To(ContainElement(moreping.IcmpProbe))
+			Expect(moreping.Probers()).To(ContainElement(moreping.RedisProtocol))
+			Expect(moreping.Probers()).To(ContainElement(moreping.PostgresProtocol))
+			Expect(moreping.Probers()).To(ContainElement(moreping.MySQLProtocol))
+		})
+
+		It("should plug in new probe types by name", func() {
+			moreping.RegisterProber("fake", func(config moreping.ProbeConfig) (moreping.Prober, error) {
+				return &fakeProber{latencies: []time.Duration{config.Timeout}}, nil
+			})
+
+			prober, err := moreping.NewProber("fake", moreping.ProbeConfig{Timeout: time.Millisecond})
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(prober.Name()).To(Equal("fake"))
+			Expect(prober.Probe(moreping.Target{IpAddress: "10.0.0.1"}).Latency).To(Equal(time.Millisecond))
+			Expect(func() {
+				moreping.RegisterProber("fake", func(config moreping.ProbeConfig) (moreping.Prober, error) { return nil, nil })
+			}).To(Panic())
+		})
+
+		It("should refuse unknown probe types", func() {
+			_, err := moreping.NewProber("carrier-pigeon", moreping.ProbeConfig{})
+
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should wrap the TCP dials into the generic envelope", func() {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			defer listener.Close()
+			port := listener.Addr().(*net.TCPAddr).Port
+			prober, err := moreping.NewProber(moreping.TCPProbe, moreping.ProbeConfig{Timeout: time.Second})
+			Expect(err).NotTo(HaveOccurred())
+
+			result := prober.Probe(moreping.Target{IpAddress: "127.0.0.1", Port: port})
+
+			Expect(result.Probe).To(Equal(moreping.TCPProbe))
+			Expect(result.IpAddress).To(Equal("127.0.0.1"))
+			Expect(result.Port).To(Equal(port))
+			Expect(result.Success).To(Equal(true))
+			Expect(result.Timestamp).NotTo(BeZero())
+		})
+	})
+
+	Describe("Targets", func() {
+
+		It("should combine the IP addresses with the ports", func() {
+			targets := moreping.Targets([]string{"10.0.0.1", "10.0.0.2"}, []int{80, 443})
+
+			Expect(targets).To(Equal([]moreping.Target{
+				{IpAddress: "10.0.0.1", Port: 80}, {IpAddress: "10.0.0.1", Port: 443},
+				{IpAddress: "10.0.0.2", Port: 80}, {IpAddress: "10.0.0.2", Port: 443},
+			}))
+			Expect(moreping.Targets([]string{"10.0.0.1"}, nil)).To(Equal([]moreping.Target{{IpAddress: "10.0.0.1"}}))
+		})
+	})
+
+	Describe("Batches", func() {
+
+		It("should calculate the stats of a batch of calls", func() {
+			prober := &fakeProber{latencies: []time.Duration{10 * time.Millisecond, 0, 30 * time.Millisecond, 20 * time.Millisecond}}
+			sink := &recordingSink{}
+
+			batch := moreping.ProbeBatchIP(prober, moreping.Target{IpAddress: "10.0.0.1", Port: 22}, 4, sink)
+
+			Expect(batch.Probe).To(Equal("fake"))
+			Expect(batch.IpAddress).To(Equal("10.0.0.1"))
+			Expect(batch.Port).To(Equal(22))
+			Expect(batch.Experiments).To(Equal(4))
+			Expect(batch.PctPcktLoss).To(Equal(float32(0.25)))
+			Expect(batch.MinLatency).To(Equal(10 * time.Millisecond))
+			Expect(batch.MaxLatency).To(Equal(30 * time.Millisecond))
+			Expect(batch.Latencies).To(HaveLen(3))
+			Expect(batch.Failures).To(Equal(map[string]int{"fake failure": 1}))
+			Expect(sink.results).To(HaveLen(4))
+		})
+
+		It("should count the failures of a batch by reason", func() {
+			failure := func(message string) moreping.ProbeResult {
+				return moreping.ProbeResult{Probe: "tcp", IpAddress: "10.0.0.1", Message: message, Latency: moreping.InfiniteLatency}
+			}
+
+			batch := moreping.NewProbeBatch([]moreping.ProbeResult{
+				failure("dial tcp 10.0.0.1:22: connect: connection refused"),
+				failure("dial tcp 10.0.0.1:22: connect: connection refused"),
+				failure("Destination unreachable (code 1) from 192.168.1.1"),
+				failure("Destination unreachable (code 1) from 192.168.1.2"),
+				failure("step 1: expecting \"^220\": EOF"),
+				failure(""),
+			})
+
+			Expect(batch.Failures).To(Equal(map[string]int{
+				"connection refused": 2, "Destination unreachable (code 1)": 2, "EOF": 1, "unknown": 1,
+			}))
+		})
+
+		It("should keep the target as given when the calls report the resolved address", func() {
+			// as the ICMP calls: the successful ones report the resolved address, the failed ones the host name
+			prober := &scriptedProber{name: "icmp", results: []moreping.ProbeResult{
+				{Probe: "icmp", IpAddress: "93.184.216.34", Success: true, Latency: time.Millisecond},
+				{Probe: "icmp", IpAddress: "example.com", Message: "This ping call is on timeout", Latency: moreping.InfiniteLatency},
+			}}
+			sink := &recordingSink{}
+
+			batches := []moreping.ProbeBatch{}
+			for i := 0; i < 2; i++ {
+				batches = append(batches, moreping.ProbeBatchIP(prober, moreping.Target{IpAddress: "example.com"}, 1, sink))
+			}
+
+			Expect(batches[0].IpAddress).To(Equal("example.com"))
+			Expect(batches[1].IpAddress).To(Equal("example.com"))
+			Expect(batches[0].PctPcktLoss).To(Equal(float32(0)))
+			Expect(batches[1].PctPcktLoss).To(Equal(float32(1)))
+			Expect(sink.results[0].IpAddress).To(Equal("example.com"))
+			Expect(sink.results[0].Details).To(HaveKeyWithValue("resolved_ip", "93.184.216.34"))
+			Expect(sink.results[1].IpAddress).To(Equal("example.com"))
+			Expect(sink.results[1].Details).NotTo(HaveKey("resolved_ip"))
+		})
+
+		It("should keep the default port dialed by the prober when the target has none", func() {
+			prober := &scriptedProber{name: "redis", results: []moreping.ProbeResult{
+				{Probe: "redis", IpAddress: "10.0.0.1", Port: 6379, Success: true, Latency: time.Millisecond},
+			}}
+			sink := &recordingSink{}
+
+			batch := moreping.ProbeBatchIP(prober, moreping.Target{IpAddress: "10.0.0.1"}, 2, sink)
+
+			Expect(batch.Port).To(Equal(6379))
+			Expect(sink.results[0].Port).To(Equal(6379))
+			Expect(sink.results[1].Port).To(Equal(6379))
+		})
+
+		It("should write the batches of every target to the sinks when scheduled", func() {
+			prober := &fakeProber{latencies: []time.Duration{time.Millisecond}}
+			sink := &recordingSink{batches: make(chan moreping.ProbeBatch)}
+			targets := moreping.Targets([]string{"10.0.0.1", "10.0.0.2"}, nil)
+
+			quit := moreping.Schedule(moreping.ProbeBatchFunc(prober, targets, 3, sink), time.Hour)
+			defer close(quit)
+
+			ips := []string{}
+			for range targets {
+				batch := <-sink.batches
+				Expect(batch.Experiments).To(Equal(3))
+				Expect(batch.PctPcktLoss).To(Equal(float32(0.0)))
+				ips = append(ips, batch.IpAddress)
+			}
+			Expect(ips).To(ConsistOf("10.0.0.1", "10.0.0.2"))
+		})
+
+		It("should log the stats of the legacy TCP batches when scheduled", func() {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			defer listener.Close()
+			port := listener.Addr().(*net.TCPAddr).Port
+			logger := make(channelLogger, 10)
+			defer func(previous moreping.StdLogger) { moreping.Logger = previous }(moreping.Logger)
+			moreping.Logger = logger
+
+			quit := moreping.Schedule(moreping.TCPBatchFunc([]string{"127.0.0.1"}, []int{port}, 2), time.Hour)
+			defer close(quit)
+
+			var stats string
+			Eventually(logger).Should(Receive(&stats))
+			Expect(stats).To(HavePrefix("Stats: moreping.ProbeBatch{"))
+			Expect(stats).To(ContainSubstring(fmt.Sprintf(`Probe:"tcp", IpAddress:"127.0.0.1", Port:%d,`, port)))
+			Expect(stats).To(ContainSubstring(`Experiments:2, PctPcktLoss:0,`))
+		})
+	})
+})
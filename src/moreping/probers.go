@@ -0,0 +1,207 @@
+package moreping
+
+import (
+	"strconv"
+	"time"
+)
+
+// The names of the built-in probe types (see also the handshake protocols).
+const (
+	TCPProbe  = "tcp"
+	IcmpProbe = "icmp"
+)
+
+func init() {
+	RegisterProber(TCPProbe, func(config ProbeConfig) (Prober, error) {
+		return &tcpProber{pinger: &tcpPinger{timeout: config.Timeout, options: config.TCP}}, nil
+	})
+	RegisterProber(IcmpProbe, func(config ProbeConfig) (Prober, error) {
+		return &icmpProber{timeout: config.Timeout, options: config.ICMP}, nil
+	})
+	for protocol := range handshakes {
+		protocol := protocol
+		RegisterProber(protocol, func(config ProbeConfig) (Prober, error) {
+			pinger, err := NewHandshakePinger(protocol, config.Timeout, nil)
+			if err != nil {
+				return nil, err
+			}
+			return &handshakeProber{protocol: protocol, pinger: pinger}, nil
+		})
+	}
+}
+
+type tcpProber struct {
+	pinger *tcpPinger
+}
+
+func (p *tcpProber) Name() string {
+	return TCPProbe
+}
+
+func (p *tcpProber) Probe(target Target) ProbeResult {
+	pinger := p.pinger
+	if target.Source.isSet() || target.Proxy != "" {
+		targetPinger := *p.pinger
+		if target.Source.isSet() {
+			targetPinger.options.Source = target.Source
+		}
+		if target.Proxy != "" {
+			targetPinger.options.Proxy = target.Proxy
+		}
+		pinger = &targetPinger
+	}
+	start := time.Now()
+	tcpCall, state := pinger.dialIP(target.IpAddress, target.Port)
+	result := ResultFromTcpCall(tcpCall, start)
+	result.Details["port_state"] = string(state)
+	return result
+}
+
+type icmpProber struct {
+	timeout time.Duration
+	options IcmpOptions
+}
+
+func (p *icmpProber) Name() string {
+	return IcmpProbe
+}
+
+func (p *icmpProber) Probe(target Target) ProbeResult {
+	options := p.options
+	if target.Source.isSet() {
+		options.Source = target.Source
+	}
+	start := time.Now()
+	// a dedicated channel, so that concurrent probes do not mix their outcomes,
+	// and no new goroutine, so that the socket is created on the calling thread
+	// (e.g. locked into a network namespace)
+	icmpChan := make(chan IcmpCall, 1)
+	NewIcmpPingerWithOptions(p.timeout, options, icmpChan).PingIP(target.IpAddress)
+	return ResultFromIcmpCall(<-icmpChan, start)
+}
+
+type handshakeProber struct {
+	protocol string
+	pinger   HandshakePinger
+}
+
+func (p *handshakeProber) Name() string {
+	return p.protocol
+}
+
+func (p *handshakeProber) Probe(target Target) ProbeResult {
+	start := time.Now()
+	return ResultFromHandshakeCall(p.pinger.HandshakeIP(target.IpAddress, target.Port), start)
+}
+
+// ResultFromTcpCall wraps a TCP call into the generic envelope.
+func ResultFromTcpCall(tcpCall TcpCall, timestamp time.Time) ProbeResult {
+	result := ProbeResult{
+		Probe:     TCPProbe,
+		IpAddress: tcpCall.IpAddress,
+		Port:      tcpCall.TcpPort,
+		Timestamp: timestamp,
+		Success:   tcpCall.Success,
+		Message:   tcpCall.Message,
+		Latency:   tcpCall.Latency,
+		Details:   map[string]string{},
+	}
+	if tcpCall.SourceIP != "" {
+		result.Details["source_ip"] = tcpCall.SourceIP
+	}
+	if tcpCall.ProxyLatency > 0 {
+		result.Details["proxy_latency"] = tcpCall.ProxyLatency.String()
+	}
+	if tcpCall.BannerLatency > 0 {
+		result.Details["banner_latency"] = tcpCall.BannerLatency.String()
+	}
+	if tcpCall.FailedStep > 0 {
+		result.Details["failed_step"] = strconv.Itoa(tcpCall.FailedStep)
+	}
+	if tcpCall.RstLatency > 0 {
+		result.Details["rst_latency"] = tcpCall.RstLatency.String()
+	}
+	return result
+}
+
+// ResultFromIcmpCall wraps an ICMP call into the generic envelope.
+func ResultFromIcmpCall(icmpCall IcmpCall, timestamp time.Time) ProbeResult {
+	result := ProbeResult{
+		Probe:     IcmpProbe,
+		IpAddress: icmpCall.IpAddress,
+		Timestamp: timestamp,
+		Success:   icmpCall.Success,
+		Message:   icmpCall.Message,
+		Latency:   icmpCall.Latency,
+		Details:   map[string]string{},
+	}
+	if icmpCall.SourceIP != "" {
+		result.Details["source_ip"] = icmpCall.SourceIP
+	}
+	if icmpCall.TTL > 0 {
+		result.Details["ttl"] = strconv.Itoa(icmpCall.TTL)
+	}
+	if icmpCall.Size > 0 {
+		result.Details["reply_size"] = strconv.Itoa(icmpCall.Size)
+	}
+	if icmpCall.From != "" {
+		result.Details["from"] = icmpCall.From
+	}
+	return result
+}
+
+// ResultFromHandshakeCall wraps a protocol handshake into the generic envelope.
+func ResultFromHandshakeCall(handshakeCall HandshakeCall, timestamp time.Time) ProbeResult {
+	result := ProbeResult{
+		Probe:     handshakeCall.Protocol,
+		IpAddress: handshakeCall.IpAddress,
+		Port:      handshakeCall.TcpPort,
+		Timestamp: timestamp,
+		Success:   handshakeCall.Success,
+		Message:   handshakeCall.Message,
+		Latency:   handshakeCall.Latency,
+		Details: map[string]string{
+			"connect_latency":   handshakeCall.ConnectLatency.String(),
+			"handshake_latency": handshakeCall.HandshakeLatency.String(),
+		},
+	}
+	if handshakeCall.ServerError != "" {
+		result.Details["server_error"] = handshakeCall.ServerError
+		if result.Message == "" {
+			result.Message = handshakeCall.ServerError
+		}
+	}
+	if handshakeCall.ServerVersion != "" {
+		result.Details["server_version"] = handshakeCall.ServerVersion
+	}
+	return result
+}
+
+// BatchFromTcpBatch wraps a TCP batch into the generic envelope
+// (the min/max latencies and the failures are not available).
+func BatchFromTcpBatch(tcpBatch TcpBatch, timestamp time.Time) ProbeBatch {
+	return ProbeBatch{
+		Probe:       TCPProbe,
+		IpAddress:   tcpBatch.IpAddress,
+		Port:        tcpBatch.TcpPort,
+		Timestamp:   timestamp,
+		Experiments: tcpBatch.Expertiments,
+		PctPcktLoss: tcpBatch.PctPcktLoss,
+		AvgLatency:  tcpBatch.AvgLatency,
+		Failures:    map[string]int{},
+	}
+}
+
+// BatchFromIcmpBatch wraps an ICMP batch into the generic envelope
+// (the min/max latencies and the failures are not available).
+func BatchFromIcmpBatch(icmpBatch IcmpBatch, timestamp time.Time) ProbeBatch {
+	return ProbeBatch{
+		Probe:       IcmpProbe,
+		IpAddress:   icmpBatch.IpAddress,
+		Timestamp:   timestamp,
+		Experiments: icmpBatch.Expertiments,
+		PctPcktLoss: icmpBatch.PctPcktLoss,
+		AvgLatency:  icmpBatch.AvgLatency,
+		Failures:    map[string]int{},
+	}
+}
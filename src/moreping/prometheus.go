@@ -0,0 +1,261 @@
+package moreping
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds (in seconds) of the buckets of the latency histograms.
+var latencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// summaryQuantiles are the quantiles of the latencies of the last batch.
+var summaryQuantiles = []float64{0, 0.5, 0.9, 0.99, 1}
+
+// seriesKey identifies the metrics of a target by probe type, IP address and port.
+type seriesKey struct {
+	probe  string
+	target string
+	port   int
+}
+
+func (k seriesKey) labels() string {
+	return fmt.Sprintf(`probe="%s",target="%s",port="%d"`, escapeLabel(k.probe), escapeLabel(k.target), k.port)
+}
+
+// probeSeries accumulates the calls and the batches of a target.
+type probeSeries struct {
+	successes      int
+	failures       int
+	failureReasons map[string]int
+	buckets        []int // the number of successful calls in each latency bucket (not cumulative)
+	latencySum     float64
+
+	batches           int
+	lastBatch         time.Time
+	lossRatio         float64
+	avgLatency        float64
+	quantiles         []float64
+	batchLatencySum   float64
+	batchLatencyCount int
+}
+
+// PrometheusSink is a result sink keeping the metrics of the calls and of the batches
+// of every target, exposed in the Prometheus text format when served over HTTP.
+type PrometheusSink struct {
+	mutex  sync.Mutex
+	series map[seriesKey]*probeSeries
+}
+
+// NewPrometheusSink creates a new sink to be served over HTTP (e.g. on /metrics).
+func NewPrometheusSink() *PrometheusSink {
+	return &PrometheusSink{series: map[seriesKey]*probeSeries{}}
+}
+
+func (p *PrometheusSink) seriesFor(probe string, target string, port int) *probeSeries {
+	key := seriesKey{probe: probe, target: target, port: port}
+	series, ok := p.series[key]
+	if !ok {
+		series = &probeSeries{failureReasons: map[string]int{}, buckets: make([]int, len(latencyBuckets))}
+		p.series[key] = series
+	}
+	return series
+}
+
+// WriteResult counts the call, and its latency when successful or its failure reason otherwise.
+func (p *PrometheusSink) WriteResult(result ProbeResult) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	series := p.seriesFor(result.Probe, result.IpAddress, result.Port)
+	if !result.Success {
+		series.failures++
+		series.failureReasons[failureClass(result.Message)]++
+		return nil
+	}
+	series.successes++
+	latency := result.Latency.Seconds()
+	series.latencySum += latency
+	for idx, upperBound := range latencyBuckets {
+		if latency <= upperBound {
+			series.buckets[idx]++
+			break
+		}
+	}
+	return nil
+}
+
+// WriteBatch keeps the loss ratio and the latency quantiles of the last batch.
+func (p *PrometheusSink) WriteBatch(batch ProbeBatch) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	series := p.seriesFor(batch.Probe, batch.IpAddress, batch.Port)
+	series.batches++
+	series.lastBatch = batch.Timestamp
+	series.lossRatio = float64(batch.PctPcktLoss)
+	series.avgLatency = batch.AvgLatency.Seconds()
+
+	latencies := make([]float64, 0, len(batch.Latencies))
+	for _, latency := range batch.Latencies {
+		latencies = append(latencies, latency.Seconds())
+		series.batchLatencySum += latency.Seconds()
+	}
+	series.batchLatencyCount += len(latencies)
+	sort.Float64s(latencies)
+	series.quantiles = make([]float64, len(summaryQuantiles))
+	for idx, quantile := range summaryQuantiles {
+		series.quantiles[idx] = math.NaN()
+		if len(latencies) > 0 {
+			series.quantiles[idx] = latencies[int(quantile*float64(len(latencies)-1)+0.5)]
+		}
+	}
+	return nil
+}
+
+// ServeHTTP exposes the metrics in the Prometheus text format.
+func (p *PrometheusSink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := p.WriteMetrics(w); err != nil {
+		Logger.Printf("Unable to write the metrics: %s\n", err)
+	}
+}
+
+// WriteMetrics writes the metrics in the Prometheus text format.
+func (p *PrometheusSink) WriteMetrics(w io.Writer) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	keys := make([]seriesKey, 0, len(p.series))
+	for key := range p.series {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].probe != keys[j].probe {
+			return keys[i].probe < keys[j].probe
+		}
+		if keys[i].target != keys[j].target {
+			return keys[i].target < keys[j].target
+		}
+		return keys[i].port < keys[j].port
+	})
+
+	bw := bufio.NewWriter(w)
+	header := func(name string, kind string, help string) {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+
+	header("moreping_probes_total", "counter", "The number of calls by outcome.")
+	for _, key := range keys {
+		series := p.series[key]
+		fmt.Fprintf(bw, "moreping_probes_total{%s,result=\"success\"} %d\n", key.labels(), series.successes)
+		fmt.Fprintf(bw, "moreping_probes_total{%s,result=\"failure\"} %d\n", key.labels(), series.failures)
+	}
+
+	header("moreping_probe_failures_total", "counter", "The number of failed calls by reason (timeout, refused, reset, unreachable, dns or other).")
+	for _, key := range keys {
+		series := p.series[key]
+		reasons := make([]string, 0, len(series.failureReasons))
+		for reason := range series.failureReasons {
+			reasons = append(reasons, reason)
+		}
+		sort.Strings(reasons)
+		for _, reason := range reasons {
+			fmt.Fprintf(bw, "moreping_probe_failures_total{%s,reason=\"%s\"} %d\n", key.labels(), escapeLabel(reason), series.failureReasons[reason])
+		}
+	}
+
+	header("moreping_probe_latency_seconds", "histogram", "The latency of the successful calls.")
+	for _, key := range keys {
+		series := p.series[key]
+		cumulative := 0
+		for idx, upperBound := range latencyBuckets {
+			cumulative += series.buckets[idx]
+			fmt.Fprintf(bw, "moreping_probe_latency_seconds_bucket{%s,le=\"%s\"} %d\n", key.labels(), formatFloat(upperBound), cumulative)
+		}
+		fmt.Fprintf(bw, "moreping_probe_latency_seconds_bucket{%s,le=\"+Inf\"} %d\n", key.labels(), series.successes)
+		fmt.Fprintf(bw, "moreping_probe_latency_seconds_sum{%s} %s\n", key.labels(), formatFloat(series.latencySum))
+		fmt.Fprintf(bw, "moreping_probe_latency_seconds_count{%s} %d\n", key.labels(), series.successes)
+	}
+
+	header("moreping_batches_total", "counter", "The number of batches of calls.")
+	for _, key := range keys {
+		fmt.Fprintf(bw, "moreping_batches_total{%s} %d\n", key.labels(), p.series[key].batches)
+	}
+
+	header("moreping_batch_loss_ratio", "gauge", "The ratio of failed calls in the last batch.")
+	for _, key := range keys {
+		if series := p.series[key]; series.batches > 0 {
+			fmt.Fprintf(bw, "moreping_batch_loss_ratio{%s} %s\n", key.labels(), formatFloat(series.lossRatio))
+		}
+	}
+
+	header("moreping_batch_avg_latency_seconds", "gauge", "The average latency of the last batch (timeouts included).")
+	for _, key := range keys {
+		if series := p.series[key]; series.batches > 0 {
+			fmt.Fprintf(bw, "moreping_batch_avg_latency_seconds{%s} %s\n", key.labels(), formatFloat(series.avgLatency))
+		}
+	}
+
+	header("moreping_batch_latency_seconds", "summary", "The latency quantiles of the successful calls of the last batch.")
+	for _, key := range keys {
+		series := p.series[key]
+		if series.batches == 0 {
+			continue
+		}
+		for idx, quantile := range summaryQuantiles {
+			fmt.Fprintf(bw, "moreping_batch_latency_seconds{%s,quantile=\"%s\"} %s\n", key.labels(), formatFloat(quantile), formatFloat(series.quantiles[idx]))
+		}
+		fmt.Fprintf(bw, "moreping_batch_latency_seconds_sum{%s} %s\n", key.labels(), formatFloat(series.batchLatencySum))
+		fmt.Fprintf(bw, "moreping_batch_latency_seconds_count{%s} %d\n", key.labels(), series.batchLatencyCount)
+	}
+
+	header("moreping_last_batch_timestamp_seconds", "gauge", "The time of the last batch as a Unix timestamp.")
+	for _, key := range keys {
+		if series := p.series[key]; series.batches > 0 {
+			fmt.Fprintf(bw, "moreping_last_batch_timestamp_seconds{%s} %d\n", key.labels(), series.lastBatch.Unix())
+		}
+	}
+	return bw.Flush()
+}
+
+// failureClasses are the fixed reasons of the failed calls, by the substrings of their error messages
+// (checked in order), so that the reason label has a bounded cardinality.
+var failureClasses = []struct {
+	class      string
+	substrings []string
+}{
+	{"dns", []string{"no such host", "lookup ", "server misbehaving"}},
+	{"timeout", []string{"timeout", "timed out", "deadline exceeded"}},
+	{"refused", []string{"connection refused", "port closed"}},
+	{"reset", []string{"connection reset", "broken pipe"}},
+	{"unreachable", []string{"unreachable", "no route to host", "host is down", "time to live exceeded"}},
+}
+
+// failureClass maps an error message onto one of the failureClasses, "other" when none matches.
+func failureClass(message string) string {
+	message = strings.ToLower(message)
+	for _, candidate := range failureClasses {
+		for _, substring := range candidate.substrings {
+			if strings.Contains(message, substring) {
+				return candidate.class
+			}
+		}
+	}
+	return "other"
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatFloat(value float64) string {
+	if math.IsNaN(value) {
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,68 @@
+package moreping_test
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/tappoz/moreping/src/moreping"
+)
+
+var _ = Describe("Prometheus", func() {
+
+	It("should expose the calls and the batches in the text format", func() {
+		sink := moreping.NewPrometheusSink()
+		prober := &fakeProber{latencies: []time.Duration{2 * time.Millisecond, 0, 30 * time.Millisecond, 4 * time.Millisecond}}
+		target := moreping.Target{IpAddress: "10.0.0.1", Port: 22}
+		batch := moreping.ProbeBatchIP(prober, target, 4, sink)
+		batch.Timestamp = time.Unix(1500000000, 0)
+		Expect(sink.WriteBatch(batch)).To(Succeed())
+
+		recorder := httptest.NewRecorder()
+		sink.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+		metrics := recorder.Body.String()
+
+		Expect(recorder.Header().Get("Content-Type")).To(ContainSubstring("text/plain; version=0.0.4"))
+		labels := `probe="fake",target="10.0.0.1",port="22"`
+		Expect(metrics).To(ContainSubstring("# TYPE moreping_probes_total counter\n"))
+		Expect(metrics).To(ContainSubstring(`moreping_probes_total{` + labels + `,result="success"} 3` + "\n"))
+		Expect(metrics).To(ContainSubstring(`moreping_probes_total{` + labels + `,result="failure"} 1` + "\n"))
+		Expect(metrics).To(ContainSubstring(`moreping_probe_failures_total{` + labels + `,reason="other"} 1` + "\n"))
+		Expect(metrics).To(ContainSubstring(`moreping_probe_latency_seconds_bucket{` + labels + `,le="0.0025"} 1` + "\n"))
+		Expect(metrics).To(ContainSubstring(`moreping_probe_latency_seconds_bucket{` + labels + `,le="0.005"} 2` + "\n"))
+		Expect(metrics).To(ContainSubstring(`moreping_probe_latency_seconds_bucket{` + labels + `,le="+Inf"} 3` + "\n"))
+		Expect(metrics).To(ContainSubstring(`moreping_probe_latency_seconds_count{` + labels + `} 3` + "\n"))
+		Expect(metrics).To(ContainSubstring(`moreping_batch_loss_ratio{` + labels + `} 0.25` + "\n"))
+		Expect(metrics).To(ContainSubstring(`moreping_batch_latency_seconds{` + labels + `,quantile="0.5"} 0.004` + "\n"))
+		Expect(metrics).To(ContainSubstring(`moreping_batch_latency_seconds{` + labels + `,quantile="1"} 0.03` + "\n"))
+		Expect(metrics).To(ContainSubstring(`moreping_last_batch_timestamp_seconds{` + labels + `} 1500000000` + "\n"))
+	})
+
+	It("should map the failures onto a fixed set of reasons and escape the labels", func() {
+		sink := moreping.NewPrometheusSink()
+		for _, message := range []string{
+			"dial tcp 10.0.0.1:80: connect: connection refused",
+			"port closed",
+			"dial tcp 10.0.0.1:80: i/o timeout",
+			"This ping call is on timeout",
+			"read tcp 10.0.0.2:4242->10.0.0.1:80: read: connection reset by peer",
+			"Destination unreachable (code 1) from 192.168.1.1",
+			"dial tcp: lookup nope.example.com: no such host",
+			"step 1: expecting \"^220\": EOF",
+		} {
+			sink.WriteResult(moreping.ProbeResult{Probe: "tcp", IpAddress: "10.0.0.1", Port: 80, Message: message})
+		}
+		sink.WriteResult(moreping.ProbeResult{Probe: `we"ird`, IpAddress: "10.0.0.1", Port: 80, Message: "no"})
+
+		recorder := httptest.NewRecorder()
+		sink.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+		labels := `probe="tcp",target="10.0.0.1",port="80"`
+		for reason, count := range map[string]int{"refused": 2, "timeout": 2, "reset": 1, "unreachable": 1, "dns": 1, "other": 1} {
+			Expect(recorder.Body.String()).To(ContainSubstring(fmt.Sprintf(`moreping_probe_failures_total{%s,reason="%s"} %d`+"\n", labels, reason, count)))
+		}
+		Expect(recorder.Body.String()).To(ContainSubstring(`moreping_probe_failures_total{probe="we\"ird",target="10.0.0.1",port="80",reason="other"} 1`))
+	})
+})
@@ -0,0 +1,175 @@
+package moreping_test
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/tappoz/moreping/src/moreping"
+)
+
+// standInProxy starts a proxy stand-in returning its address
+func standInProxy(handle func(conn net.Conn)) (string, func()) {
+	listener, _ := standInServer(handle)
+	return listener.Addr().String(), func() { listener.Close() }
+}
+
+// tunnel dials the target then pipes the bytes in both directions
+func tunnel(conn net.Conn, target net.Conn) {
+	defer target.Close()
+	go io.Copy(target, conn)
+	io.Copy(conn, target)
+}
+
+// socks5Proxy is a minimal SOCKS5 proxy (IPv4 targets, optional username/password)
+func socks5Proxy(user string, password string) func(conn net.Conn) {
+	return func(conn net.Conn) {
+		greeting := make([]byte, 2)
+		io.ReadFull(conn, greeting)
+		io.ReadFull(conn, make([]byte, greeting[1]))
+		if user == "" {
+			conn.Write([]byte{5, 0})
+		} else {
+			conn.Write([]byte{5, 2})
+			header := make([]byte, 2)
+			io.ReadFull(conn, header)
+			gotUser := make([]byte, header[1])
+			io.ReadFull(conn, gotUser)
+			io.ReadFull(conn, header[:1])
+			gotPassword := make([]byte, header[0])
+			io.ReadFull(conn, gotPassword)
+			if string(gotUser) != user || string(gotPassword) != password {
+				conn.Write([]byte{1, 1})
+				return
+			}
+			conn.Write([]byte{1, 0})
+		}
+		request := make([]byte, 10)
+		io.ReadFull(conn, request)
+		address := net.JoinHostPort(net.IP(request[4:8]).String(), strconv.Itoa(int(binary.BigEndian.Uint16(request[8:10]))))
+		target, err := net.Dial("tcp", address)
+		if err != nil {
+			conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+			return
+		}
+		conn.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 0})
+		tunnel(conn, target)
+	}
+}
+
+// httpConnectProxy is a minimal HTTP CONNECT proxy
+func httpConnectProxy(conn net.Conn) {
+	request, err := http.ReadRequest(bufio.NewReader(conn))
+	if err != nil || request.Method != http.MethodConnect {
+		conn.Write([]byte("HTTP/1.1 405 Method Not Allowed\r\n\r\n"))
+		return
+	}
+	target, err := net.Dial("tcp", request.Host)
+	if err != nil {
+		conn.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n"))
+		return
+	}
+	conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+	tunnel(conn, target)
+}
+
+var _ = Describe("Proxy", func() {
+
+	dialTimeout := time.Second
+	var targetPort int
+	var closeTarget func()
+
+	BeforeEach(func() {
+		// an SSH-like server sending its banner right away
+		var listener net.Listener
+		listener, targetPort = standInServer(func(conn net.Conn) {
+			conn.Write([]byte("SSH-2.0-StandIn\r\n"))
+			io.Copy(ioutil.Discard, conn)
+		})
+		closeTarget = func() { listener.Close() }
+	})
+
+	AfterEach(func() {
+		closeTarget()
+	})
+
+	dialThrough := func(proxyURL string) moreping.TcpCall {
+		options := moreping.TCPOptions{
+			Proxy:  proxyURL,
+			Script: []moreping.TcpStep{{Expect: mustParseSteps("expect:^SSH-2.0-")[0].Expect}},
+		}
+		pinger := moreping.NewTCPPingerWithOptions([]int{targetPort}, dialTimeout, options, nil)
+		return pinger.DialIP("127.0.0.1", targetPort)
+	}
+
+	It("should dial through a SOCKS5 proxy", func() {
+		proxyAddress, closeProxy := standInProxy(socks5Proxy("", ""))
+		defer closeProxy()
+
+		tcpCall := dialThrough("socks5://" + proxyAddress)
+
+		Expect(tcpCall.Success).To(Equal(true), tcpCall.Message)
+		Expect(tcpCall.ProxyLatency).Should(BeNumerically(">", 0))
+		Expect(tcpCall.ProxyLatency).Should(BeNumerically("<=", tcpCall.Latency))
+	})
+
+	It("should authenticate to a SOCKS5 proxy", func() {
+		proxyAddress, closeProxy := standInProxy(socks5Proxy("alice", "secret"))
+		defer closeProxy()
+
+		Expect(dialThrough("socks5://alice:secret@" + proxyAddress).Success).To(Equal(true))
+		tcpCall := dialThrough("socks5://alice:wrong@" + proxyAddress)
+		Expect(tcpCall.Success).To(Equal(false))
+		Expect(tcpCall.Message).To(ContainSubstring("authentication failed"))
+	})
+
+	It("should dial through an HTTP CONNECT proxy keeping the early banners", func() {
+		proxyAddress, closeProxy := standInProxy(httpConnectProxy)
+		defer closeProxy()
+
+		tcpCall := dialThrough("http://" + proxyAddress)
+
+		Expect(tcpCall.Success).To(Equal(true), tcpCall.Message)
+		Expect(tcpCall.ProxyLatency).Should(BeNumerically(">", 0))
+		Expect(tcpCall.BannerLatency).Should(BeNumerically(">", 0))
+	})
+
+	It("should classify the ports refused behind the proxy as closed", func() {
+		proxyAddress, closeProxy := standInProxy(socks5Proxy("", ""))
+		defer closeProxy()
+		closeTarget()
+
+		portScans := moreping.NewPortScanner(dialTimeout, moreping.TCPOptions{Proxy: "socks5://" + proxyAddress}, 1).ScanIP("127.0.0.1", []int{targetPort})
+
+		Expect(portScans[0].State).To(Equal(moreping.PortClosed))
+		Expect(portScans[0].Message).To(ContainSubstring("connection refused"))
+	})
+
+	It("should report the unreachable proxies and override the proxy per target", func() {
+		prober, err := moreping.NewProber(moreping.TCPProbe, moreping.ProbeConfig{Timeout: dialTimeout, TCP: moreping.TCPOptions{Proxy: "socks5://127.0.0.1:1"}})
+		Expect(err).NotTo(HaveOccurred())
+		proxyAddress, closeProxy := standInProxy(httpConnectProxy)
+		defer closeProxy()
+
+		result := prober.Probe(moreping.Target{IpAddress: "127.0.0.1", Port: targetPort})
+		Expect(result.Success).To(Equal(false))
+		Expect(result.Message).To(ContainSubstring("unable to connect to the proxy"))
+
+		result = prober.Probe(moreping.Target{IpAddress: "127.0.0.1", Port: targetPort, Proxy: "http://" + proxyAddress})
+		Expect(result.Success).To(Equal(true), result.Message)
+		Expect(result.Details).To(HaveKey("proxy_latency"))
+	})
+
+	It("should refuse invalid proxies", func() {
+		for _, proxyURL := range []string{"ftp://127.0.0.1:21", "socks5://127.0.0.1", "::"} {
+			Expect(dialThrough(proxyURL).Message).To(ContainSubstring("invalid proxy"))
+		}
+	})
+})
@@ -0,0 +1,319 @@
+package moreping
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+// ReportOptions holds the layout of the HTML report. The zero values are replaced by the defaults.
+type ReportOptions struct {
+	Title  string // "moreping report" by default
+	Width  int    // the width of the charts in pixels (default 800)
+	Height int    // the height of the latency charts in pixels (default 220, half of it for the loss charts)
+}
+
+// reportTarget is the section of a target in the report.
+type reportTarget struct {
+	Summary      ProbeBatch
+	Batches      int
+	LatencyChart template.HTML
+	LossChart    template.HTML
+	Failures     []reportFailure
+}
+
+type reportFailure struct {
+	Message string
+	Count   int
+}
+
+// WriteHTMLReport writes a self-contained HTML report of the batches of the history (no scripts
+// nor external resources): a summary table, then for every target its latency over time (the mean
+// of the successful calls of every batch within the min-max band), its loss of every batch and its
+// failure reasons. All the charts share the same time range.
+func WriteHTMLReport(w io.Writer, history History, options ReportOptions) error {
+	if options.Title == "" {
+		options.Title = "moreping report"
+	}
+	if options.Width <= 0 {
+		options.Width = 800
+	}
+	if options.Height <= 0 {
+		options.Height = 220
+	}
+
+	series := map[seriesKey][]ProbeBatch{}
+	var from, to time.Time
+	for _, batch := range history.Batches {
+		key := seriesKey{probe: batch.Probe, target: batch.IpAddress, port: batch.Port}
+		series[key] = append(series[key], batch)
+		if from.IsZero() || batch.Timestamp.Before(from) {
+			from = batch.Timestamp
+		}
+		if batch.Timestamp.After(to) {
+			to = batch.Timestamp
+		}
+	}
+
+	summaries := SummarizeBatches(history.Batches)
+	targets := make([]reportTarget, 0, len(summaries))
+	for _, summary := range summaries {
+		batches := series[seriesKey{probe: summary.Probe, target: summary.IpAddress, port: summary.Port}]
+		sort.SliceStable(batches, func(i, j int) bool { return batches[i].Timestamp.Before(batches[j].Timestamp) })
+		target := reportTarget{
+			Summary:      summary,
+			Batches:      len(batches),
+			LatencyChart: latencyChart(batches, from, to, options.Width, options.Height),
+			LossChart:    lossChart(batches, from, to, options.Width, options.Height/2),
+		}
+		for message, count := range summary.Failures {
+			target.Failures = append(target.Failures, reportFailure{Message: message, Count: count})
+		}
+		sort.Slice(target.Failures, func(i, j int) bool {
+			if target.Failures[i].Count != target.Failures[j].Count {
+				return target.Failures[i].Count > target.Failures[j].Count
+			}
+			return target.Failures[i].Message < target.Failures[j].Message
+		})
+		targets = append(targets, target)
+	}
+
+	return reportTemplate.Execute(w, struct {
+		Title     string
+		Generated time.Time
+		From      time.Time
+		To        time.Time
+		Targets   []reportTarget
+	}{options.Title, time.Now(), from, to, targets})
+}
+
+// meanLatency is the mean latency of the successful calls of a batch.
+func meanLatency(batch ProbeBatch) time.Duration {
+	var total time.Duration
+	for _, latency := range batch.Latencies {
+		total += latency
+	}
+	return total / time.Duration(len(batch.Latencies))
+}
+
+func latencyChart(batches []ProbeBatch, from time.Time, to time.Time, width int, height int) template.HTML {
+	var maxLatency time.Duration
+	for _, batch := range batches {
+		if len(batch.Latencies) > 0 && batch.MaxLatency > maxLatency {
+			maxLatency = batch.MaxLatency
+		}
+	}
+	chart := newSVGChart(width, height, from, to, niceCeil(milliseconds(maxLatency)))
+	chart.axes(func(value float64) string { return formatFloat(value) + " ms" })
+
+	// the min-max band and the mean line, interrupted by the batches without successful calls
+	var band, line bytes.Buffer
+	var upper, lower []string
+	flushBand := func() {
+		if len(upper) == 0 {
+			return
+		}
+		band.WriteString("M")
+		for idx := range upper {
+			fmt.Fprintf(&band, " %s", upper[idx])
+		}
+		for idx := len(lower) - 1; idx >= 0; idx-- {
+			fmt.Fprintf(&band, " %s", lower[idx])
+		}
+		band.WriteString(" Z ")
+		upper, lower = nil, nil
+	}
+	command := "M"
+	for _, batch := range batches {
+		if len(batch.Latencies) == 0 {
+			flushBand()
+			command = "M"
+			continue
+		}
+		x := chart.x(batch.Timestamp)
+		upper = append(upper, fmt.Sprintf("%.1f,%.1f", x, chart.y(milliseconds(batch.MaxLatency))))
+		lower = append(lower, fmt.Sprintf("%.1f,%.1f", x, chart.y(milliseconds(batch.MinLatency))))
+		fmt.Fprintf(&line, "%s %.1f,%.1f ", command, x, chart.y(milliseconds(meanLatency(batch))))
+		command = "L"
+	}
+	flushBand()
+	if band.Len() > 0 {
+		fmt.Fprintf(&chart.buf, `<path d="%s" fill="#9ecae1" fill-opacity="0.5" stroke="#9ecae1"/>`, band.String())
+		fmt.Fprintf(&chart.buf, `<path d="%s" fill="none" stroke="#08519c" stroke-width="1.5"/>`, line.String())
+	} else {
+		chart.message("no successful calls")
+	}
+	return chart.end()
+}
+
+func lossChart(batches []ProbeBatch, from time.Time, to time.Time, width int, height int) template.HTML {
+	chart := newSVGChart(width, height, from, to, 100)
+	chart.axes(func(value float64) string { return formatFloat(value) + "%" })
+	barWidth := math.Max(1, chart.plotWidth()/float64(len(batches)+1))
+	for _, batch := range batches {
+		if batch.PctPcktLoss <= 0 {
+			continue
+		}
+		loss := float64(batch.PctPcktLoss) * 100
+		fmt.Fprintf(&chart.buf, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"><title>%s: %.1f%%</title></rect>`,
+			chart.x(batch.Timestamp)-barWidth/2, chart.y(loss), barWidth, chart.y(0)-chart.y(loss), lossColor(batch.PctPcktLoss),
+			batch.Timestamp.Format("2006-01-02 15:04:05"), loss)
+	}
+	return chart.end()
+}
+
+// lossColor goes from orange (some loss) to red (all the calls failed).
+func lossColor(lossRatio float32) string {
+	switch {
+	case lossRatio >= 1:
+		return "#cb181d"
+	case lossRatio >= 0.5:
+		return "#fb6a4a"
+	default:
+		return "#fd8d3c"
+	}
+}
+
+// niceCeil rounds a value up to 1, 2 or 5 times a power of 10 (1 at least), for the axes.
+func niceCeil(value float64) float64 {
+	if value <= 1 {
+		return 1
+	}
+	magnitude := math.Pow(10, math.Floor(math.Log10(value)))
+	for _, step := range []float64{1, 2, 5, 10} {
+		if value <= step*magnitude {
+			return step * magnitude
+		}
+	}
+	return 10 * magnitude
+}
+
+// chartScale maps the times to the x axis and the values from 0 to maxY to the y axis
+// of a chart, within its margins.
+type chartScale struct {
+	width  int
+	height int
+	from   time.Time
+	to     time.Time
+	maxY   float64
+}
+
+// the margins of the plot area within the charts
+const (
+	chartMarginLeft   = 70
+	chartMarginRight  = 15
+	chartMarginTop    = 10
+	chartMarginBottom = 25
+)
+
+func (c chartScale) plotWidth() float64 {
+	return float64(c.width - chartMarginLeft - chartMarginRight)
+}
+
+func (c chartScale) plotHeight() float64 {
+	return float64(c.height - chartMarginTop - chartMarginBottom)
+}
+
+// x is the horizontal position of a time (the middle of the chart when the range is empty).
+func (c chartScale) x(t time.Time) float64 {
+	span := c.to.Sub(c.from)
+	if span <= 0 {
+		return chartMarginLeft + c.plotWidth()/2
+	}
+	return chartMarginLeft + c.plotWidth()*float64(t.Sub(c.from))/float64(span)
+}
+
+// y is the vertical position of a value, capped to the plot area.
+func (c chartScale) y(value float64) float64 {
+	value = math.Max(0, math.Min(value, c.maxY))
+	return chartMarginTop + c.plotHeight()*(1-value/c.maxY)
+}
+
+// svgChart draws a time series chart in SVG.
+type svgChart struct {
+	chartScale
+	buf bytes.Buffer
+}
+
+func newSVGChart(width int, height int, from time.Time, to time.Time, maxY float64) *svgChart {
+	chart := &svgChart{chartScale: chartScale{width: width, height: height, from: from, to: to, maxY: maxY}}
+	fmt.Fprintf(&chart.buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="sans-serif" font-size="11">`,
+		width, height, width, height)
+	return chart
+}
+
+// axes draws the horizontal grid lines with their labels and the start and end times.
+func (c *svgChart) axes(label func(value float64) string) {
+	const ticks = 4
+	for tick := 0; tick <= ticks; tick++ {
+		value := c.maxY * float64(tick) / ticks
+		y := c.y(value)
+		fmt.Fprintf(&c.buf, `<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" stroke="#ddd"/>`, chartMarginLeft, y, c.width-chartMarginRight, y)
+		fmt.Fprintf(&c.buf, `<text x="%d" y="%.1f" text-anchor="end" dominant-baseline="middle" fill="#555">%s</text>`,
+			chartMarginLeft-5, y, template.HTMLEscapeString(label(value)))
+	}
+	bottom := c.height - chartMarginBottom
+	fmt.Fprintf(&c.buf, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="#888"/>`, chartMarginLeft, bottom, c.width-chartMarginRight, bottom)
+	fmt.Fprintf(&c.buf, `<text x="%d" y="%d" fill="#555">%s</text>`, chartMarginLeft, c.height-8, c.from.Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(&c.buf, `<text x="%d" y="%d" text-anchor="end" fill="#555">%s</text>`, c.width-chartMarginRight, c.height-8, c.to.Format("2006-01-02 15:04:05"))
+}
+
+// message writes a text in the middle of the plot area.
+func (c *svgChart) message(text string) {
+	fmt.Fprintf(&c.buf, `<text x="%.1f" y="%.1f" text-anchor="middle" fill="#888">%s</text>`,
+		chartMarginLeft+c.plotWidth()/2, chartMarginTop+c.plotHeight()/2, template.HTMLEscapeString(text))
+}
+
+func (c *svgChart) end() template.HTML {
+	c.buf.WriteString("</svg>")
+	return template.HTML(c.buf.String())
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"time":         func(t time.Time) string { return t.Format("2006-01-02 15:04:05 MST") },
+	"ms":           func(d time.Duration) string { return fmt.Sprintf("%.2f", milliseconds(d)) },
+	"pct":          func(ratio float32) string { return fmt.Sprintf("%.1f", ratio*100) },
+	"hasLatencies": func(batch ProbeBatch) bool { return len(batch.Latencies) > 0 },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin: 1em 0; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.8em; text-align: right; }
+th { background: #f0f0f0; }
+td.text { text-align: left; }
+.loss { color: #cb181d; font-weight: bold; }
+.meta { color: #666; }
+section { margin-top: 2.5em; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{if .Targets}}<p class="meta">From {{time .From}} to {{time .To}}, generated on {{time .Generated}}</p>
+<table>
+<tr><th>Probe</th><th>Target</th><th>Port</th><th>Batches</th><th>Calls</th><th>Loss %</th><th>Avg ms</th><th>Min ms</th><th>Max ms</th></tr>
+{{range .Targets}}{{with .Summary}}<tr><td class="text">{{.Probe}}</td><td class="text"><a href="#{{.Probe}}-{{.IpAddress}}-{{.Port}}">{{.IpAddress}}</a></td><td>{{.Port}}</td>{{end}}<td>{{.Batches}}</td>{{with .Summary}}<td>{{.Experiments}}</td><td{{if gt .PctPcktLoss 0.0}} class="loss"{{end}}>{{pct .PctPcktLoss}}</td><td>{{ms .AvgLatency}}</td>{{if hasLatencies .}}<td>{{ms .MinLatency}}</td><td>{{ms .MaxLatency}}</td>{{else}}<td>-</td><td>-</td>{{end}}</tr>{{end}}
+{{end}}</table>
+{{range .Targets}}<section id="{{.Summary.Probe}}-{{.Summary.IpAddress}}-{{.Summary.Port}}">
+<h2>{{.Summary.Probe}} {{.Summary.IpAddress}}{{if .Summary.Port}}:{{.Summary.Port}}{{end}}</h2>
+<h3>Latency</h3>
+{{.LatencyChart}}
+<h3>Loss</h3>
+{{.LossChart}}
+{{if .Failures}}<h3>Failures</h3>
+<table>
+<tr><th>Reason</th><th>Calls</th></tr>
+{{range .Failures}}<tr><td class="text">{{.Message}}</td><td>{{.Count}}</td></tr>
+{{end}}</table>{{end}}
+</section>
+{{end}}{{else}}<p>No batches in the selected time range.</p>
+{{end}}</body>
+</html>
+`))
@@ -0,0 +1,47 @@
+package moreping_test
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/tappoz/moreping/src/moreping"
+)
+
+var _ = Describe("HTML report", func() {
+
+	start := time.Date(2017, time.July, 14, 2, 40, 0, 0, time.UTC)
+
+	It("should write the summary, the charts and the failures of every target", func() {
+		history := moreping.History{Batches: []moreping.ProbeBatch{
+			{Probe: "tcp", IpAddress: "10.0.0.1", Port: 22, Timestamp: start, Experiments: 2, AvgLatency: 2 * time.Millisecond,
+				MinLatency: time.Millisecond, MaxLatency: 3 * time.Millisecond, Latencies: []time.Duration{time.Millisecond, 3 * time.Millisecond}},
+			{Probe: "tcp", IpAddress: "10.0.0.1", Port: 22, Timestamp: start.Add(time.Minute), Experiments: 2, PctPcktLoss: 1,
+				AvgLatency: moreping.InfiniteLatency, Failures: map[string]int{"<timeout>": 2}},
+			{Probe: "icmp", IpAddress: "10.0.0.2", Timestamp: start.Add(2 * time.Minute), Experiments: 2, PctPcktLoss: 1,
+				AvgLatency: moreping.InfiniteLatency, Failures: map[string]int{"no reply": 2}},
+		}}
+		output := &bytes.Buffer{}
+		Expect(moreping.WriteHTMLReport(output, history, moreping.ReportOptions{Title: "Incident 42"})).To(Succeed())
+		report := output.String()
+
+		Expect(report).To(HavePrefix("<!DOCTYPE html>"))
+		Expect(report).To(ContainSubstring("<title>Incident 42</title>"))
+		Expect(strings.Count(report, "<svg")).To(Equal(4))
+		Expect(report).To(ContainSubstring(`<a href="#tcp-10.0.0.1-22">10.0.0.1</a>`))
+		Expect(report).To(ContainSubstring("<td>4</td><td class=\"loss\">50.0</td>"))
+		Expect(report).To(ContainSubstring("&lt;timeout&gt;"))
+		Expect(report).NotTo(ContainSubstring("<timeout>"))
+		Expect(report).To(ContainSubstring("no successful calls"))
+		Expect(report).NotTo(ContainSubstring("<script"))
+		Expect(report).NotTo(MatchRegexp(`(src|href)="http`))
+	})
+
+	It("should write a report without batches", func() {
+		output := &bytes.Buffer{}
+		Expect(moreping.WriteHTMLReport(output, moreping.History{}, moreping.ReportOptions{})).To(Succeed())
+		Expect(output.String()).To(ContainSubstring("No batches in the selected time range."))
+	})
+})
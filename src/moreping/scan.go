@@ -0,0 +1,195 @@
+package moreping
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// PortState is the state of a TCP port as classified by a scan (nmap-like).
+type PortState string
+
+// The states of the scanned TCP ports.
+const (
+	// PortOpen means the dial succeeded (or a SYN-ACK was received)
+	PortOpen PortState = "open"
+	// PortClosed means the host refused the connection (a RST was received)
+	PortClosed PortState = "closed"
+	// PortFiltered means no answer at all (timeout) or an unreachable host
+	PortFiltered PortState = "filtered"
+)
+
+// portStateFromDialError classifies the port state from the error of a TCP dial.
+func portStateFromDialError(err error) PortState {
+	if err == nil {
+		return PortOpen
+	}
+	if proxyErr, ok := err.(*proxyError); ok && proxyErr.refused {
+		return PortClosed
+	}
+	if opErr, ok := err.(*net.OpError); ok {
+		if sysErr, ok := opErr.Err.(*os.SyscallError); ok && sysErr.Err == syscall.ECONNREFUSED {
+			return PortClosed
+		}
+	}
+	return PortFiltered
+}
+
+// topPorts are the most common TCP ports (by frequency, as in nmap) with their service names.
+var topPorts = []struct {
+	port    int
+	service string
+}{
+	{80, "http"}, {23, "telnet"}, {443, "https"}, {21, "ftp"}, {22, "ssh"},
+	{25, "smtp"}, {3389, "ms-wbt-server"}, {110, "pop3"}, {445, "microsoft-ds"}, {139, "netbios-ssn"},
+	{143, "imap"}, {53, "domain"}, {135, "msrpc"}, {3306, "mysql"}, {8080, "http-proxy"},
+	{1723, "pptp"}, {111, "rpcbind"}, {995, "pop3s"}, {993, "imaps"}, {5900, "vnc"},
+	{1025, "NFS-or-IIS"}, {587, "submission"}, {8888, "sun-answerbook"}, {199, "smux"}, {1720, "h323q931"},
+	{465, "smtps"}, {548, "afp"}, {113, "ident"}, {81, "hosts2-ns"}, {6001, "X11:1"},
+	{10000, "snet-sensor-mgmt"}, {514, "shell"}, {5060, "sip"}, {179, "bgp"}, {1026, "LSA-or-nterm"},
+	{2000, "cisco-sccp"}, {8443, "https-alt"}, {8000, "http-alt"}, {32768, "filenet-tms"}, {554, "rtsp"},
+	{26, "rsftp"}, {1433, "ms-sql-s"}, {49152, "unknown"}, {2001, "dc"}, {515, "printer"},
+	{8008, "http"}, {49154, "unknown"}, {1027, "IIS"}, {5666, "nrpe"}, {646, "ldp"},
+	{5000, "upnp"}, {5631, "pcanywheredata"}, {631, "ipp"}, {49153, "unknown"}, {8081, "blackice-icecap"},
+	{2049, "nfs"}, {88, "kerberos-sec"}, {79, "finger"}, {5800, "vnc-http"}, {106, "pop3pw"},
+	{2121, "ccproxy-ftp"}, {1110, "nfsd-status"}, {49155, "unknown"}, {6000, "X11"}, {513, "login"},
+	{990, "ftps"}, {5357, "wsdapi"}, {427, "svrloc"}, {49156, "unknown"}, {543, "klogin"},
+	{544, "kshell"}, {5101, "admdog"}, {144, "news"}, {7, "echo"}, {389, "ldap"},
+	{8009, "ajp13"}, {3128, "squid-http"}, {444, "snpp"}, {9999, "abyss"}, {5009, "airport-admin"},
+	{7070, "realserver"}, {5190, "aol"}, {3000, "ppp"}, {5432, "postgresql"}, {1900, "upnp"},
+	{3986, "mapper-ws_ethd"}, {13, "daytime"}, {1029, "ms-lsa"}, {9, "discard"}, {5051, "ida-agent"},
+	{6646, "unknown"}, {49157, "unknown"}, {1028, "unknown"}, {873, "rsync"}, {1755, "wms"},
+	{2717, "pn-requester"}, {4899, "radmin"}, {9100, "jetdirect"}, {119, "nntp"}, {37, "time"},
+}
+
+// TopPorts returns the n most common TCP ports (at most 100, none for a negative n).
+func TopPorts(n int) []int {
+	if n > len(topPorts) {
+		n = len(topPorts)
+	}
+	if n < 0 {
+		n = 0
+	}
+	ports := make([]int, 0, n)
+	for _, topPort := range topPorts[:n] {
+		ports = append(ports, topPort.port)
+	}
+	return ports
+}
+
+// ServiceName returns the well known service name of a TCP port, empty if unknown.
+func ServiceName(port int) string {
+	for _, topPort := range topPorts {
+		if topPort.port == port {
+			return topPort.service
+		}
+	}
+	return ""
+}
+
+// ParsePorts parses a list of TCP ports and port ranges, e.g. "22,80,8000-8100".
+// The returned ports are sorted and without duplicates.
+func ParsePorts(spec string) ([]int, error) {
+	seen := map[int]bool{}
+	ports := []int{}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		first, last := item, item
+		if dash := strings.Index(item, "-"); dash >= 0 {
+			first, last = item[:dash], item[dash+1:]
+		}
+		from, err := parsePort(first)
+		if err != nil {
+			return nil, err
+		}
+		to, err := parsePort(last)
+		if err != nil {
+			return nil, err
+		}
+		if from > to {
+			return nil, fmt.Errorf("invalid port range: %s", item)
+		}
+		for port := from; port <= to; port++ {
+			if !seen[port] {
+				seen[port] = true
+				ports = append(ports, port)
+			}
+		}
+	}
+	if len(ports) == 0 {
+		return nil, fmt.Errorf("no ports in %q", spec)
+	}
+	sort.Ints(ports)
+	return ports, nil
+}
+
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(s)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("invalid port: %q", s)
+	}
+	return port, nil
+}
+
+// PortScanner scans lists of TCP ports of an IP address classifying each of them
+// as open, closed or filtered based on the outcome of the dial.
+type PortScanner interface {
+	ScanIP(targetIP string, ports []int) []PortScan
+}
+
+type portScanner struct {
+	pinger      *tcpPinger
+	concurrency int
+}
+
+// NewPortScanner creates a new instance of the port scanner, dialing up to
+// `concurrency` ports at the same time. With the SYN mode option the ports are
+// probed with half-open SYN probes (root user only).
+func NewPortScanner(timeout time.Duration, options TCPOptions, concurrency int) PortScanner {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &portScanner{
+		pinger:      &tcpPinger{timeout: timeout, options: options},
+		concurrency: concurrency,
+	}
+}
+
+// ScanIP dials all the given ports returning their states sorted by port.
+func (p *portScanner) ScanIP(targetIP string, ports []int) []PortScan {
+	scans := make([]PortScan, len(ports))
+	semaphore := make(chan struct{}, p.concurrency)
+	var wg sync.WaitGroup
+	for idx, port := range ports {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(idx int, port int) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			tcpCall, state := p.pinger.dialIP(targetIP, port)
+			scans[idx] = PortScan{
+				IpAddress: targetIP,
+				TcpPort:   port,
+				State:     state,
+				Service:   ServiceName(port),
+				Latency:   tcpCall.Latency,
+				Message:   tcpCall.Message,
+			}
+		}(idx, port)
+	}
+	wg.Wait()
+	sort.Slice(scans, func(i, j int) bool { return scans[i].TcpPort < scans[j].TcpPort })
+	Logger.Printf("Done scanning %d TCP ports of %s\n", len(ports), targetIP)
+	return scans
+}
@@ -0,0 +1,82 @@
+package moreping_test
+
+import (
+	"net"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/tappoz/moreping/src/moreping"
+)
+
+var _ = Describe("Scan", func() {
+
+	Describe("Parsing the ports", func() {
+
+		It("should parse lists of ports and port ranges, sorted and without duplicates", func() {
+			ports, err := moreping.ParsePorts("8000-8003, 22,80,8001")
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ports).To(Equal([]int{22, 80, 8000, 8001, 8002, 8003}))
+		})
+
+		It("should refuse invalid ports and ranges", func() {
+			for _, spec := range []string{"", "http", "0", "65536", "80-22", "22-", "1-2-3"} {
+				_, err := moreping.ParsePorts(spec)
+				Expect(err).To(HaveOccurred(), spec)
+			}
+		})
+
+		It("should provide the top N common ports with their service names", func() {
+			Expect(moreping.TopPorts(5)).To(Equal([]int{80, 23, 443, 21, 22}))
+			Expect(moreping.TopPorts(1000)).To(HaveLen(100))
+			Expect(moreping.TopPorts(-1)).To(BeEmpty())
+			Expect(moreping.ServiceName(22)).To(Equal("ssh"))
+			Expect(moreping.ServiceName(12345)).To(BeEmpty())
+		})
+	})
+
+	Describe("Port scanner", func() {
+
+		scanTimeout := 300 * time.Millisecond
+
+		It("should classify the ports of localhost as open or closed", func() {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			defer listener.Close()
+			openPort := listener.Addr().(*net.TCPAddr).Port
+			closedListener, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			closedPort := closedListener.Addr().(*net.TCPAddr).Port
+			closedListener.Close()
+
+			portScans := moreping.NewPortScanner(scanTimeout, moreping.TCPOptions{}, 10).ScanIP("127.0.0.1", []int{closedPort, openPort})
+
+			Expect(portScans).To(HaveLen(2))
+			for _, portScan := range portScans {
+				Expect(portScan.IpAddress).To(Equal("127.0.0.1"))
+				switch portScan.TcpPort {
+				case openPort:
+					Expect(portScan.State).To(Equal(moreping.PortOpen))
+				case closedPort:
+					Expect(portScan.State).To(Equal(moreping.PortClosed))
+				default:
+					Fail("unexpected port")
+				}
+			}
+			Expect(portScans[0].TcpPort).Should(BeNumerically("<", portScans[1].TcpPort))
+		})
+
+		It("should classify the ports of localhost with SYN probes", func() {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			defer listener.Close()
+			openPort := listener.Addr().(*net.TCPAddr).Port
+
+			portScans := moreping.NewPortScanner(scanTimeout, moreping.TCPOptions{SynMode: true}, 10).ScanIP("127.0.0.1", []int{openPort})
+
+			Expect(portScans).To(HaveLen(1))
+			Expect(portScans[0].State).To(Equal(moreping.PortOpen))
+		})
+	})
+})
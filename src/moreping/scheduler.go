@@ -4,23 +4,30 @@ import (
 	"time"
 )
 
-var tcpBatchChan = make(chan TcpBatch)
-var icmpBatchChan = make(chan IcmpBatch)
-
-// TCPBatchFunc is a "func" type that can be used to schedule TCP dials
+// TCPBatchFunc is a "func" type that can be used to schedule TCP dials,
+// the stats of the batches are printed via the `Logger`
 func TCPBatchFunc(websites []string, tcpPorts []int, batchSize int) func() {
-	tcpPinger := NewTCPBatchPinger(tcpPorts, 1*time.Second, tcpBatchChan)
-	return func() {
-		tcpPinger.SpawnTCPDialBatches(websites, batchSize)
-	}
+	return TCPBatchFuncWithOptions(websites, tcpPorts, batchSize, TCPOptions{})
+}
+
+// TCPBatchFuncWithOptions is a "func" type that can be used to schedule TCP dials
+// with the given options (e.g. a send/expect script to run after connecting)
+func TCPBatchFuncWithOptions(websites []string, tcpPorts []int, batchSize int, options TCPOptions) func() {
+	prober := &tcpProber{pinger: &tcpPinger{timeout: 1 * time.Second, options: options}}
+	return ProbeBatchFunc(prober, Targets(websites, tcpPorts), batchSize, NewLoggerSink())
 }
 
-// IcmpBatchFunc is a "func" type that can be used to schedule ICMP calls
+// IcmpBatchFunc is a "func" type that can be used to schedule ICMP calls,
+// the stats of the batches are printed via the `Logger`
 func IcmpBatchFunc(websites []string, batchSize int) func() {
-	icmpPinger := NewIcmpBatchPinger(1*time.Second, icmpBatchChan)
-	return func() {
-		icmpPinger.SpawnBatchPings(websites, batchSize)
-	}
+	return IcmpBatchFuncWithOptions(websites, batchSize, IcmpOptions{})
+}
+
+// IcmpBatchFuncWithOptions is a "func" type that can be used to schedule ICMP calls
+// with custom packet parameters (size, TTL, TOS and payload pattern)
+func IcmpBatchFuncWithOptions(websites []string, batchSize int, options IcmpOptions) func() {
+	prober := &icmpProber{timeout: 1 * time.Second, options: options}
+	return ProbeBatchFunc(prober, Targets(websites, nil), batchSize, NewLoggerSink())
 }
 
 // Schedule can be used to schedule any of the "func" types.
@@ -34,10 +41,6 @@ func Schedule(f func(), recurring time.Duration) chan struct{} {
 			case <-ticker.C:
 				// Logger.Printf("! Ticked")
 				go inFunc()
-			case tcpMsg := <-tcpBatchChan:
-				Logger.Printf("Stats: %#v", tcpMsg)
-			case icmpMsg := <-icmpBatchChan:
-				Logger.Printf("Stats: %#v", icmpMsg)
 			case <-quit:
 				// Logger.Printf("! Stopping the scheduler")
 				ticker.Stop()
@@ -0,0 +1,97 @@
+package moreping
+
+import (
+	"sync"
+	"time"
+)
+
+// ResultSink consumes the outcomes of the probes: every single call
+// and the stats of every batch of calls.
+type ResultSink interface {
+	WriteResult(result ProbeResult) error
+	WriteBatch(batch ProbeBatch) error
+}
+
+type multiSink struct {
+	mutex sync.Mutex
+	sinks []ResultSink
+}
+
+// NewMultiSink creates a sink writing to all the given sinks.
+// The writes are serialized, so the sinks do not need to be safe for concurrent use.
+// The first error is returned, but all the sinks are written anyway.
+func NewMultiSink(sinks ...ResultSink) ResultSink {
+	return &multiSink{sinks: sinks}
+}
+
+func (m *multiSink) WriteResult(result ProbeResult) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var firstErr error
+	for _, sink := range m.sinks {
+		if err := sink.WriteResult(result); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (m *multiSink) WriteBatch(batch ProbeBatch) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var firstErr error
+	for _, sink := range m.sinks {
+		if err := sink.WriteBatch(batch); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// LegacySink adapts a result sink to the structs of the TCP and ICMP pingers,
+// e.g. `NewLegacySink(csvSink).WriteTcpBatch(tcpBatch)`. They are timestamped now.
+type LegacySink struct {
+	ResultSink
+}
+
+// NewLegacySink creates an adapter of the sink to the structs of the TCP and ICMP pingers.
+func NewLegacySink(sink ResultSink) LegacySink {
+	return LegacySink{ResultSink: sink}
+}
+
+// WriteTcpCall writes a TCP call.
+func (l LegacySink) WriteTcpCall(tcpCall TcpCall) error {
+	return l.WriteResult(ResultFromTcpCall(tcpCall, time.Now()))
+}
+
+// WriteIcmpCall writes an ICMP call.
+func (l LegacySink) WriteIcmpCall(icmpCall IcmpCall) error {
+	return l.WriteResult(ResultFromIcmpCall(icmpCall, time.Now()))
+}
+
+// WriteTcpBatch writes a TCP batch.
+func (l LegacySink) WriteTcpBatch(tcpBatch TcpBatch) error {
+	return l.WriteBatch(BatchFromTcpBatch(tcpBatch, time.Now()))
+}
+
+// WriteIcmpBatch writes an ICMP batch.
+func (l LegacySink) WriteIcmpBatch(icmpBatch IcmpBatch) error {
+	return l.WriteBatch(BatchFromIcmpBatch(icmpBatch, time.Now()))
+}
+
+type loggerSink struct{}
+
+// NewLoggerSink creates a sink printing the stats of every batch via the `Logger`,
+// which is what the TCP and ICMP batch funcs (e.g. `TCPBatchFunc(...)`) do.
+func NewLoggerSink() ResultSink {
+	return loggerSink{}
+}
+
+func (loggerSink) WriteResult(result ProbeResult) error {
+	return nil
+}
+
+func (loggerSink) WriteBatch(batch ProbeBatch) error {
+	Logger.Printf("Stats: %#v", batch)
+	return nil
+}
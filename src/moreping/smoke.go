@@ -0,0 +1,267 @@
+package moreping
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+// SmokeOptions holds the layout of the smoke graphs. The zero values are replaced by the defaults.
+type SmokeOptions struct {
+	Title      string        // written at the top of the SVG graphs (the PNG graphs have no title)
+	Width      int           // in pixels (default 800)
+	Height     int           // in pixels (default 250)
+	MaxLatency time.Duration // the top of the latency axis, from the medians by default
+}
+
+// smokeLossColors are the colors of the median line by loss ratio, as in SmokePing.
+var smokeLossColors = []struct {
+	upTo  float32
+	color color.NRGBA
+}{
+	{0, color.NRGBA{0x26, 0xb5, 0x00, 0xff}},
+	{0.05, color.NRGBA{0x00, 0xb8, 0xff, 0xff}},
+	{0.10, color.NRGBA{0x00, 0x59, 0xff, 0xff}},
+	{0.15, color.NRGBA{0x5e, 0x00, 0xff, 0xff}},
+	{0.20, color.NRGBA{0x7e, 0x00, 0xff, 0xff}},
+	{0.50, color.NRGBA{0xdd, 0x00, 0xff, 0xff}},
+	{1, color.NRGBA{0xff, 0x00, 0x00, 0xff}},
+}
+
+var (
+	smokeBackground = color.NRGBA{0xff, 0xff, 0xff, 0xff}
+	smokeGrid       = color.NRGBA{0xdd, 0xdd, 0xdd, 0xff}
+	smokeAxis       = color.NRGBA{0x88, 0x88, 0x88, 0xff}
+	smokeText       = color.NRGBA{0x55, 0x55, 0x55, 0xff}
+	smokeAllLost    = color.NRGBA{0xff, 0x00, 0x00, 0x30}
+)
+
+func smokeLossColor(lossRatio float32) color.NRGBA {
+	for _, lossColor := range smokeLossColors {
+		if lossRatio <= lossColor.upTo {
+			return lossColor.color
+		}
+	}
+	return smokeLossColors[len(smokeLossColors)-1].color
+}
+
+// smokeCanvas is what the smoke graphs are drawn on: rectangles and single line texts
+// (anchored at their start, middle or end, and vertically centered on y).
+type smokeCanvas interface {
+	fill(x0, y0, x1, y1 float64, c color.NRGBA)
+	text(x, y float64, anchor string, s string, c color.NRGBA)
+}
+
+// WriteSmokeSVG writes the smoke graph of the batches of a target in SVG.
+func WriteSmokeSVG(w io.Writer, batches []ProbeBatch, options SmokeOptions) error {
+	options = smokeDefaults(options)
+	canvas := &svgCanvas{}
+	fmt.Fprintf(&canvas.buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="sans-serif" font-size="11">`,
+		options.Width, options.Height, options.Width, options.Height)
+	drawSmoke(canvas, batches, options)
+	canvas.buf.WriteString("</svg>\n")
+	_, err := w.Write(canvas.buf.Bytes())
+	return err
+}
+
+// WriteSmokePNG writes the smoke graph of the batches of a target in PNG.
+func WriteSmokePNG(w io.Writer, batches []ProbeBatch, options SmokeOptions) error {
+	options = smokeDefaults(options)
+	options.Title = ""
+	canvas := &pngCanvas{img: image.NewRGBA(image.Rect(0, 0, options.Width, options.Height))}
+	drawSmoke(canvas, batches, options)
+	return png.Encode(w, canvas.img)
+}
+
+func smokeDefaults(options SmokeOptions) SmokeOptions {
+	if options.Width <= 0 {
+		options.Width = 800
+	}
+	if options.Height <= 0 {
+		options.Height = 250
+	}
+	return options
+}
+
+// drawSmoke draws the smoke graph as SmokePing does: for every batch the latencies of the
+// successful calls are sorted and every pair of symmetric samples (the fastest and the slowest,
+// the second fastest and the second slowest...) is drawn as a translucent gray bar, so that the
+// smoke is darker where the samples are dense. The median is drawn on top, colored by the loss of
+// the batch, and the batches where all the calls failed are highlighted in red.
+func drawSmoke(canvas smokeCanvas, batches []ProbeBatch, options SmokeOptions) {
+	batches = append([]ProbeBatch(nil), batches...)
+	sort.SliceStable(batches, func(i, j int) bool { return batches[i].Timestamp.Before(batches[j].Timestamp) })
+	scale := chartScale{width: options.Width, height: options.Height, maxY: 1}
+	if len(batches) > 0 {
+		scale.from, scale.to = batches[0].Timestamp, batches[len(batches)-1].Timestamp
+	}
+
+	medians := make([]time.Duration, len(batches))
+	var maxMedian time.Duration
+	for idx, batch := range batches {
+		medians[idx] = medianLatency(batch)
+		if medians[idx] > maxMedian {
+			maxMedian = medians[idx]
+		}
+	}
+	// as SmokePing leave some room above the medians for the smoke
+	scale.maxY = niceCeil(milliseconds(maxMedian) * 2)
+	if options.MaxLatency > 0 {
+		scale.maxY = milliseconds(options.MaxLatency)
+	}
+
+	canvas.fill(0, 0, float64(options.Width), float64(options.Height), smokeBackground)
+	const ticks = 4
+	for tick := 0; tick <= ticks; tick++ {
+		value := scale.maxY * float64(tick) / ticks
+		y := scale.y(value)
+		canvas.fill(chartMarginLeft, y, float64(options.Width-chartMarginRight), y+1, smokeGrid)
+		canvas.text(chartMarginLeft-5, y, "end", formatFloat(value)+" ms", smokeText)
+	}
+	if options.Title != "" {
+		canvas.text(float64(options.Width)/2, chartMarginTop/2+2, "middle", options.Title, smokeText)
+	}
+
+	barWidth := scale.plotWidth()
+	if len(batches) > 1 {
+		barWidth = math.Max(1, barWidth/float64(len(batches)))
+	}
+	for idx, batch := range batches {
+		x0 := scale.x(batch.Timestamp) - barWidth/2
+		x1 := x0 + barWidth
+		if len(batch.Latencies) == 0 {
+			canvas.fill(x0, scale.y(scale.maxY), x1, scale.y(0), smokeAllLost)
+			continue
+		}
+		latencies := append([]time.Duration(nil), batch.Latencies...)
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		layers := len(latencies) / 2
+		if layers > 0 {
+			// the layers add up to 60% of opacity around the median
+			alpha := uint8(255 * (1 - math.Pow(0.4, 1/float64(layers))))
+			for layer := 0; layer < layers; layer++ {
+				top, bottom := scale.y(milliseconds(latencies[len(latencies)-1-layer])), scale.y(milliseconds(latencies[layer]))
+				canvas.fill(x0, top, x1, math.Max(bottom, top+1), color.NRGBA{0, 0, 0, alpha})
+			}
+		}
+		y := scale.y(milliseconds(medians[idx]))
+		canvas.fill(x0, y-1, x1, y+1, smokeLossColor(batch.PctPcktLoss))
+	}
+
+	bottom := float64(options.Height - chartMarginBottom)
+	canvas.fill(chartMarginLeft, bottom, float64(options.Width-chartMarginRight), bottom+1, smokeAxis)
+	if len(batches) > 0 {
+		canvas.text(chartMarginLeft, float64(options.Height)-10, "start", scale.from.Format("2006-01-02 15:04"), smokeText)
+		canvas.text(float64(options.Width-chartMarginRight), float64(options.Height)-10, "end", scale.to.Format("2006-01-02 15:04"), smokeText)
+	} else {
+		canvas.text(chartMarginLeft+scale.plotWidth()/2, chartMarginTop+scale.plotHeight()/2, "middle", "no batches", smokeText)
+	}
+}
+
+// medianLatency is the median latency of the successful calls of a batch (0 if none).
+func medianLatency(batch ProbeBatch) time.Duration {
+	if len(batch.Latencies) == 0 {
+		return 0
+	}
+	latencies := append([]time.Duration(nil), batch.Latencies...)
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	middle := len(latencies) / 2
+	if len(latencies)%2 == 0 {
+		return (latencies[middle-1] + latencies[middle]) / 2
+	}
+	return latencies[middle]
+}
+
+type svgCanvas struct {
+	buf bytes.Buffer
+}
+
+func (s *svgCanvas) fill(x0, y0, x1, y1 float64, c color.NRGBA) {
+	fmt.Fprintf(&s.buf, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="#%02x%02x%02x"`, x0, y0, x1-x0, y1-y0, c.R, c.G, c.B)
+	if c.A != 0xff {
+		fmt.Fprintf(&s.buf, ` fill-opacity="%.3f"`, float64(c.A)/0xff)
+	}
+	s.buf.WriteString("/>")
+}
+
+func (s *svgCanvas) text(x, y float64, anchor string, text string, c color.NRGBA) {
+	fmt.Fprintf(&s.buf, `<text x="%.1f" y="%.1f" text-anchor="%s" dominant-baseline="middle" fill="#%02x%02x%02x">%s</text>`,
+		x, y, anchor, c.R, c.G, c.B, template.HTMLEscapeString(text))
+}
+
+type pngCanvas struct {
+	img *image.RGBA
+}
+
+func (p *pngCanvas) fill(x0, y0, x1, y1 float64, c color.NRGBA) {
+	rect := image.Rect(int(math.Floor(x0)), int(math.Floor(y0)), int(math.Ceil(x1)), int(math.Ceil(y1)))
+	draw.Draw(p.img, rect, &image.Uniform{c}, image.ZP, draw.Over)
+}
+
+// the glyphs of the PNG texts are drawn from bitmapFont, scaled up
+const (
+	glyphScale   = 2
+	glyphWidth   = 3 * glyphScale
+	glyphHeight  = 5 * glyphScale
+	glyphAdvance = glyphWidth + glyphScale
+)
+
+// text draws the texts made of the characters of bitmapFont only (e.g. not the messages).
+func (p *pngCanvas) text(x, y float64, anchor string, text string, c color.NRGBA) {
+	for _, char := range text {
+		if _, ok := bitmapFont[char]; !ok {
+			return
+		}
+	}
+	width := float64(len(text)*glyphAdvance - glyphScale)
+	switch anchor {
+	case "middle":
+		x -= width / 2
+	case "end":
+		x -= width
+	}
+	top := y - glyphHeight/2
+	for idx, char := range text {
+		glyph := bitmapFont[char]
+		left := x + float64(idx*glyphAdvance)
+		for row, bits := range glyph {
+			for col, bit := range bits {
+				if bit == '#' {
+					p.fill(left+float64(col*glyphScale), top+float64(row*glyphScale),
+						left+float64((col+1)*glyphScale), top+float64((row+1)*glyphScale), c)
+				}
+			}
+		}
+	}
+}
+
+// bitmapFont is a 3x5 pixel font with the characters of the axis labels.
+var bitmapFont = map[rune][5]string{
+	'0': {"###", "#.#", "#.#", "#.#", "###"},
+	'1': {".#.", "##.", ".#.", ".#.", "###"},
+	'2': {"###", "..#", "###", "#..", "###"},
+	'3': {"###", "..#", "###", "..#", "###"},
+	'4': {"#.#", "#.#", "###", "..#", "..#"},
+	'5': {"###", "#..", "###", "..#", "###"},
+	'6': {"###", "#..", "###", "#.#", "###"},
+	'7': {"###", "..#", ".#.", ".#.", ".#."},
+	'8': {"###", "#.#", "###", "#.#", "###"},
+	'9': {"###", "#.#", "###", "..#", "###"},
+	'.': {"...", "...", "...", "...", ".#."},
+	':': {"...", ".#.", "...", ".#.", "..."},
+	'-': {"...", "...", "###", "...", "..."},
+	'%': {"#.#", "..#", ".#.", "#..", "#.#"},
+	'm': {"...", "...", "###", "###", "#.#"},
+	's': {"...", ".##", ".#.", "..#", "##."},
+	'e': {"...", "###", "###", "#..", "###"},
+	'+': {"...", ".#.", "###", ".#.", "..."},
+	' ': {"...", "...", "...", "...", "..."},
+}
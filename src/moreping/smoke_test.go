@@ -0,0 +1,60 @@
+package moreping_test
+
+import (
+	"bytes"
+	"image/color"
+	"image/png"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/tappoz/moreping/src/moreping"
+)
+
+var _ = Describe("Smoke graphs", func() {
+
+	start := time.Date(2017, time.July, 14, 2, 40, 0, 0, time.UTC)
+	batches := []moreping.ProbeBatch{
+		{Probe: "icmp", IpAddress: "10.0.0.1", Timestamp: start.Add(time.Minute), Experiments: 4, PctPcktLoss: 1},
+		{Probe: "icmp", IpAddress: "10.0.0.1", Timestamp: start, Experiments: 4,
+			Latencies: []time.Duration{4 * time.Millisecond, time.Millisecond, 2 * time.Millisecond, 3 * time.Millisecond}},
+		{Probe: "icmp", IpAddress: "10.0.0.1", Timestamp: start.Add(2 * time.Minute), Experiments: 4, PctPcktLoss: 0.25,
+			Latencies: []time.Duration{2 * time.Millisecond, 2 * time.Millisecond, 2 * time.Millisecond}},
+	}
+
+	It("should draw the smoke, the medians colored by loss and the lost batches in SVG", func() {
+		output := &bytes.Buffer{}
+		Expect(moreping.WriteSmokeSVG(output, batches, moreping.SmokeOptions{Title: "icmp 10.0.0.1 <lab>"})).To(Succeed())
+		graph := output.String()
+		Expect(graph).To(HavePrefix("<svg"))
+		Expect(graph).To(HaveSuffix("</svg>\n"))
+		Expect(graph).To(ContainSubstring("icmp 10.0.0.1 &lt;lab&gt;"))
+		// the medians: 2.5 ms without loss, 2 ms with 25% loss, on a 5 ms axis
+		Expect(graph).To(ContainSubstring(">5 ms</text>"))
+		Expect(graph).To(ContainSubstring(`fill="#26b500"`))
+		Expect(graph).To(ContainSubstring(`fill="#dd00ff"`))
+		// 2 layers of smoke for the first batch, 1 for the last one, and the lost batch
+		Expect(strings.Count(graph, `fill="#000000"`)).To(Equal(3))
+		Expect(graph).To(ContainSubstring(`fill="#ff0000" fill-opacity="0.188"`))
+	})
+
+	It("should draw the same graph in PNG", func() {
+		output := &bytes.Buffer{}
+		Expect(moreping.WriteSmokePNG(output, batches, moreping.SmokeOptions{Width: 400, Height: 150})).To(Succeed())
+		img, err := png.Decode(output)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(img.Bounds().Dx()).To(Equal(400))
+		Expect(img.Bounds().Dy()).To(Equal(150))
+
+		colors := map[color.RGBA]bool{}
+		for x := 0; x < 400; x++ {
+			for y := 0; y < 150; y++ {
+				colors[color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)] = true
+			}
+		}
+		Expect(colors).To(HaveKey(color.RGBA{0x26, 0xb5, 0x00, 0xff}))
+		Expect(colors).To(HaveKey(color.RGBA{0xdd, 0x00, 0xff, 0xff}))
+		Expect(colors).To(HaveKey(color.RGBA{0x55, 0x55, 0x55, 0xff}))
+	})
+})
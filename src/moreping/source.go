@@ -0,0 +1,70 @@
+package moreping
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// tracerouteUDPPort is the destination port used to look up the source IP address
+// of the ICMP calls (any port would do, nothing is sent).
+const tracerouteUDPPort = 33434
+
+var errSourceUnsupported = errors.New("binding to an interface or setting a socket mark is only supported on Linux")
+
+// SourceOptions selects where the calls leave from on multi-homed hosts.
+// The zero value lets the routing table of the operating system decide.
+type SourceOptions struct {
+	IP        string // the local IP address to bind to
+	Interface string // the network interface to bind to (SO_BINDTODEVICE, root user)
+	Mark      int    // the socket mark for policy routing (SO_MARK, root user)
+}
+
+func (o SourceOptions) isSet() bool {
+	return o.IP != "" || o.Interface != "" || o.Mark != 0
+}
+
+func (o SourceOptions) validate() error {
+	if o.IP != "" && net.ParseIP(o.IP).To4() == nil {
+		return fmt.Errorf("invalid source IPv4 address: %s", o.IP)
+	}
+	if o.Mark < 0 {
+		return fmt.Errorf("invalid socket mark: %d", o.Mark)
+	}
+	return nil
+}
+
+// dialer creates a dialer bound to the source IP address, the interface and the mark.
+func (o SourceOptions) dialer(timeout time.Duration) *net.Dialer {
+	dialer := &net.Dialer{Timeout: timeout, Control: o.control}
+	if o.IP != "" {
+		dialer.LocalAddr = &net.TCPAddr{IP: net.ParseIP(o.IP)}
+	}
+	return dialer
+}
+
+// sourceIPFor finds the local IP address the kernel would use to reach the destination
+// from this source, connecting a UDP socket which does not send anything.
+func (o SourceOptions) sourceIPFor(dst net.IP, port int) (net.IP, error) {
+	dialer := o.dialer(0)
+	if o.IP != "" {
+		dialer.LocalAddr = &net.UDPAddr{IP: net.ParseIP(o.IP)}
+	}
+	conn, err := dialer.Dial("udp4", net.JoinHostPort(dst.String(), strconv.Itoa(port)))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.To4(), nil
+}
+
+// localIP returns the IP address of the local end of a connection.
+func localIP(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.LocalAddr().String())
+	if err != nil {
+		return ""
+	}
+	return host
+}
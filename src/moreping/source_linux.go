@@ -0,0 +1,48 @@
+package moreping
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// control applies the interface and the mark to the sockets of a dialer.
+func (o SourceOptions) control(network string, address string, c syscall.RawConn) error {
+	var err error
+	if ctrlErr := c.Control(func(fd uintptr) {
+		err = o.applyTo(int(fd))
+	}); ctrlErr != nil {
+		return ctrlErr
+	}
+	return err
+}
+
+// applyTo binds a socket to the interface and sets the mark.
+func (o SourceOptions) applyTo(fd int) error {
+	if o.Interface != "" {
+		if err := syscall.BindToDevice(fd, o.Interface); err != nil {
+			return os.NewSyscallError("setsockopt SO_BINDTODEVICE", err)
+		}
+	}
+	if o.Mark != 0 {
+		if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_MARK, o.Mark); err != nil {
+			return os.NewSyscallError("setsockopt SO_MARK", err)
+		}
+	}
+	return nil
+}
+
+// bindRaw applies the source options to a raw socket, including the source IP address.
+func (o SourceOptions) bindRaw(fd int) error {
+	if err := o.applyTo(fd); err != nil {
+		return err
+	}
+	if o.IP != "" {
+		sa := &syscall.SockaddrInet4{}
+		copy(sa.Addr[:], net.ParseIP(o.IP).To4())
+		if err := syscall.Bind(fd, sa); err != nil {
+			return os.NewSyscallError("bind", err)
+		}
+	}
+	return nil
+}
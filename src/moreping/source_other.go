@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package moreping
+
+import (
+	"syscall"
+)
+
+func (o SourceOptions) control(network string, address string, c syscall.RawConn) error {
+	if o.Interface != "" || o.Mark != 0 {
+		return errSourceUnsupported
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package moreping_test
+
+import (
+	"net"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/tappoz/moreping/src/moreping"
+)
+
+var _ = Describe("Source", func() {
+
+	dialTimeout := 300 * time.Millisecond
+	var listener net.Listener
+	var port int
+
+	BeforeEach(func() {
+		var err error
+		listener, err = net.Listen("tcp", "0.0.0.0:0")
+		Expect(err).NotTo(HaveOccurred())
+		port = listener.Addr().(*net.TCPAddr).Port
+	})
+
+	AfterEach(func() {
+		listener.Close()
+	})
+
+	It("should record the source IP address of the TCP dials", func() {
+		tcpChan := make(chan moreping.TcpCall)
+		pinger := moreping.NewTCPPinger([]int{port}, dialTimeout, tcpChan)
+
+		tcpCall := pinger.DialIP("127.0.0.1", port)
+
+		Expect(tcpCall.Success).To(Equal(true))
+		Expect(tcpCall.SourceIP).To(Equal("127.0.0.1"))
+	})
+
+	It("should dial from the given source IP address", func() {
+		tcpChan := make(chan moreping.TcpCall)
+		options := moreping.TCPOptions{Source: moreping.SourceOptions{IP: "127.0.0.2"}}
+		pinger := moreping.NewTCPPingerWithOptions([]int{port}, dialTimeout, options, tcpChan)
+
+		tcpCall := pinger.DialIP("127.0.0.1", port)
+
+		Expect(tcpCall.Success).To(Equal(true), tcpCall.Message)
+		Expect(tcpCall.SourceIP).To(Equal("127.0.0.2"))
+	})
+
+	It("should fail to dial from an invalid source", func() {
+		tcpChan := make(chan moreping.TcpCall)
+		for _, source := range []moreping.SourceOptions{{IP: "not-an-ip"}, {Interface: "nonexistent0"}} {
+			options := moreping.TCPOptions{Source: source}
+			pinger := moreping.NewTCPPingerWithOptions([]int{port}, dialTimeout, options, tcpChan)
+
+			tcpCall := pinger.DialIP("127.0.0.1", port)
+
+			Expect(tcpCall.Success).To(Equal(false))
+			Expect(tcpCall.Message).NotTo(BeEmpty())
+		}
+	})
+
+	It("should override the source per target", func() {
+		prober, err := moreping.NewProber(moreping.TCPProbe, moreping.ProbeConfig{Timeout: dialTimeout})
+		Expect(err).NotTo(HaveOccurred())
+
+		result := prober.Probe(moreping.Target{IpAddress: "127.0.0.1", Port: port, Source: moreping.SourceOptions{IP: "127.0.0.3", Interface: "lo", Mark: 42}})
+
+		Expect(result.Success).To(Equal(true), result.Message)
+		Expect(result.Details["source_ip"]).To(Equal("127.0.0.3"))
+	})
+})
@@ -0,0 +1,110 @@
+package moreping
+
+import (
+	"sync"
+	"time"
+)
+
+// TargetState is the health of a target from its last batch.
+type TargetState string
+
+// The states of the targets.
+const (
+	StateUp       TargetState = "up"       // all the calls of the batch were successful
+	StateDegraded TargetState = "degraded" // some calls failed
+	StateDown     TargetState = "down"     // all the calls failed
+)
+
+// StateOf is the state of a target from the loss of a batch.
+func StateOf(batch ProbeBatch) TargetState {
+	switch {
+	case batch.PctPcktLoss <= 0:
+		return StateUp
+	case batch.PctPcktLoss >= 1:
+		return StateDown
+	default:
+		return StateDegraded
+	}
+}
+
+// StateChange is the transition of a target from a state to another.
+type StateChange struct {
+	Probe     string
+	IpAddress string
+	Port      int
+	Previous  TargetState  // empty for the first batch of the target
+	State     TargetState  // the state from the last batch
+	Since     time.Time    // when the target entered the previous state (zero for the first batch)
+	Batches   []ProbeBatch // the recent batches of the target, the last one caused the transition
+}
+
+// Last is the batch which caused the transition.
+func (c StateChange) Last() ProbeBatch {
+	return c.Batches[len(c.Batches)-1]
+}
+
+// Failures counts the failure reasons (as "i/o timeout" from the messages) of the recent batches.
+func (c StateChange) Failures() map[string]int {
+	failures := map[string]int{}
+	for _, batch := range c.Batches {
+		for message, count := range batch.Failures {
+			failures[failureReason(message)] += count
+		}
+	}
+	return failures
+}
+
+type trackedTarget struct {
+	state   TargetState
+	since   time.Time
+	batches []ProbeBatch
+}
+
+// StateTracker follows the state of the targets from their batches, telling the transitions.
+// The first batch of a target is a transition only when the target is not up
+// (e.g. to alert on the targets down from the start, but not on all the others).
+type StateTracker struct {
+	mutex   sync.Mutex
+	recent  int
+	targets map[seriesKey]*trackedTarget
+}
+
+// NewStateTracker creates a tracker keeping the recent batches of every target (5 by default).
+func NewStateTracker(recent int) *StateTracker {
+	if recent <= 0 {
+		recent = 5
+	}
+	return &StateTracker{recent: recent, targets: map[seriesKey]*trackedTarget{}}
+}
+
+// Update accounts the batch, telling whether the state of its target changed.
+func (t *StateTracker) Update(batch ProbeBatch) (StateChange, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	key := seriesKey{probe: batch.Probe, target: batch.IpAddress, port: batch.Port}
+	target, ok := t.targets[key]
+	if !ok {
+		target = &trackedTarget{}
+		t.targets[key] = target
+	}
+	target.batches = append(target.batches, batch)
+	if len(target.batches) > t.recent {
+		target.batches = target.batches[len(target.batches)-t.recent:]
+	}
+
+	state := StateOf(batch)
+	if state == target.state {
+		return StateChange{}, false
+	}
+	change := StateChange{
+		Probe:     batch.Probe,
+		IpAddress: batch.IpAddress,
+		Port:      batch.Port,
+		Previous:  target.state,
+		State:     state,
+		Since:     target.since,
+		Batches:   append([]ProbeBatch(nil), target.batches...),
+	}
+	target.state, target.since = state, batch.Timestamp
+	return change, change.Previous != "" || state != StateUp
+}
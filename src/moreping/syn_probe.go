@@ -0,0 +1,19 @@
+package moreping
+
+import (
+	"errors"
+	"time"
+)
+
+// errSynUnsupported is returned when the raw TCP sockets needed by the SYN probes
+// are not available on the current platform.
+var errSynUnsupported = errors.New("TCP SYN probes are not supported on this platform")
+
+// synOutcome models the answer to a SYN segment: a SYN-ACK for an open port,
+// a RST for a closed one, or nothing within the timeout.
+type synOutcome struct {
+	latency  time.Duration
+	answered bool
+	open     bool
+	source   string // the local IP address of the probe
+}
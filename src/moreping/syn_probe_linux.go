@@ -0,0 +1,152 @@
+package moreping
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+const (
+	tcpFlagSyn = 0x02
+	tcpFlagRst = 0x04
+	tcpFlagAck = 0x10
+)
+
+// synProbe sends a TCP SYN segment via a raw socket measuring the time to the SYN-ACK
+// (or RST) at packet level. No connection is established: an open port is torn down
+// with a RST, so the target application never sees the probe.
+func synProbe(targetIP string, targetPort int, timeout time.Duration, source SourceOptions) (synOutcome, error) {
+	outcome := synOutcome{}
+	ra, err := net.ResolveIPAddr("ip4", targetIP)
+	if err != nil {
+		return outcome, err
+	}
+	dst := ra.IP.To4()
+	src, err := source.sourceIPFor(dst, targetPort)
+	if err != nil {
+		return outcome, err
+	}
+	outcome.source = src.String()
+
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_RAW, syscall.IPPROTO_TCP)
+	if err != nil {
+		return outcome, os.NewSyscallError("socket", err)
+	}
+	defer syscall.Close(fd)
+	if err := source.bindRaw(fd); err != nil {
+		return outcome, err
+	}
+
+	srcPort := 40000 + rand.Intn(20000)
+	seq := rand.Uint32()
+	sa := &syscall.SockaddrInet4{}
+	copy(sa.Addr[:], dst)
+
+	start := time.Now()
+	syn := tcpSegment(src, dst, srcPort, targetPort, seq, 0, tcpFlagSyn)
+	if err := syscall.Sendto(fd, syn, 0, sa); err != nil {
+		return outcome, os.NewSyscallError("sendto", err)
+	}
+
+	rb := make([]byte, 1500)
+	deadline := start.Add(timeout)
+	for {
+		remaining := deadline.Sub(time.Now())
+		if remaining <= 0 {
+			return outcome, nil
+		}
+		tv := syscall.NsecToTimeval(remaining.Nanoseconds())
+		if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+			return outcome, os.NewSyscallError("setsockopt", err)
+		}
+		n, _, err := syscall.Recvfrom(fd, rb, 0)
+		if err == syscall.EAGAIN || err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return outcome, os.NewSyscallError("recvfrom", err)
+		}
+		flags, ok := matchSynAnswer(rb[:n], dst, targetPort, srcPort, seq+1)
+		if !ok {
+			continue
+		}
+		outcome.latency = time.Now().Sub(start)
+		outcome.answered = true
+		outcome.open = flags&(tcpFlagSyn|tcpFlagAck) == tcpFlagSyn|tcpFlagAck
+		if outcome.open {
+			// tear down the half-open connection
+			rst := tcpSegment(src, dst, srcPort, targetPort, seq+1, 0, tcpFlagRst)
+			syscall.Sendto(fd, rst, 0, sa)
+		}
+		return outcome, nil
+	}
+}
+
+// tcpSegment builds a TCP segment without payload (with the MSS option on SYN segments).
+func tcpSegment(src net.IP, dst net.IP, srcPort int, dstPort int, seq uint32, ack uint32, flags byte) []byte {
+	headerSize := 20
+	if flags&tcpFlagSyn != 0 {
+		headerSize = 24
+	}
+	b := make([]byte, headerSize)
+	binary.BigEndian.PutUint16(b[0:2], uint16(srcPort))
+	binary.BigEndian.PutUint16(b[2:4], uint16(dstPort))
+	binary.BigEndian.PutUint32(b[4:8], seq)
+	binary.BigEndian.PutUint32(b[8:12], ack)
+	b[12] = byte(headerSize/4) << 4
+	b[13] = flags
+	binary.BigEndian.PutUint16(b[14:16], 64240) // window
+	if headerSize == 24 {
+		copy(b[20:24], []byte{2, 4, 0x05, 0xb4}) // MSS 1460
+	}
+
+	// checksum on the pseudo header and the segment
+	pseudo := make([]byte, 12, 12+len(b))
+	copy(pseudo[0:4], src)
+	copy(pseudo[4:8], dst)
+	pseudo[9] = syscall.IPPROTO_TCP
+	binary.BigEndian.PutUint16(pseudo[10:12], uint16(len(b)))
+	binary.BigEndian.PutUint16(b[16:18], internetChecksum(append(pseudo, b...)))
+	return b
+}
+
+func internetChecksum(b []byte) uint16 {
+	sum := uint32(0)
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i : i+2]))
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = (sum & 0xffff) + (sum >> 16)
+	}
+	return ^uint16(sum)
+}
+
+// matchSynAnswer parses a received IPv4 packet returning the TCP flags
+// when it is the answer to our SYN segment.
+func matchSynAnswer(b []byte, dst net.IP, dstPort int, srcPort int, ack uint32) (byte, bool) {
+	if len(b) < 20 || b[9] != syscall.IPPROTO_TCP || !net.IP(b[12:16]).Equal(dst) {
+		return 0, false
+	}
+	ihl := int(b[0]&0x0f) * 4
+	if len(b) < ihl+20 {
+		return 0, false
+	}
+	tcp := b[ihl:]
+	if int(binary.BigEndian.Uint16(tcp[0:2])) != dstPort || int(binary.BigEndian.Uint16(tcp[2:4])) != srcPort {
+		return 0, false
+	}
+	flags := tcp[13]
+	if flags&tcpFlagAck == 0 || binary.BigEndian.Uint32(tcp[8:12]) != ack {
+		return 0, false
+	}
+	if flags&tcpFlagRst == 0 && flags&tcpFlagSyn == 0 {
+		return 0, false
+	}
+	return flags, true
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package moreping
+
+import (
+	"time"
+)
+
+func synProbe(targetIP string, targetPort int, timeout time.Duration, source SourceOptions) (synOutcome, error) {
+	return synOutcome{}, errSynUnsupported
+}
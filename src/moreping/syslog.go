@@ -0,0 +1,315 @@
+package moreping
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The syslog severities of the messages (RFC 5424).
+const (
+	syslogError   = 3
+	syslogWarning = 4
+	syslogNotice  = 5
+	syslogInfo    = 6
+)
+
+// The syslog facilities of the messages, by name. The kern facility (0) is left out:
+// it is reserved to the kernel messages, and 0 is the unset facility of the options.
+var syslogFacilities = map[string]int{
+	"user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// SyslogFacility is the code of a facility by name, e.g. 16 for "local0".
+func SyslogFacility(name string) (int, error) {
+	facility, ok := syslogFacilities[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown syslog facility: %s", name)
+	}
+	return facility, nil
+}
+
+// The MSGID of the syslog messages.
+const (
+	syslogBatchID = "batch"
+	syslogStateID = "state"
+	syslogLogID   = "log"
+)
+
+// syslogEnterpriseID is the private enterprise number of the structured data IDs,
+// the one reserved for the documentation (RFC 5612) by default.
+const syslogEnterpriseID = 32473
+
+// SyslogOptions configures the syslog messages. The zero values are replaced by the defaults.
+type SyslogOptions struct {
+	Facility     int           // the facility of the messages (default local0, i.e. 16)
+	AppName      string        // the APP-NAME of the messages (default moreping)
+	Hostname     string        // the HOSTNAME of the messages (default the host name)
+	EnterpriseID int           // the private enterprise number of the structured data ID moreping@<number> (default 32473)
+	Timeout      time.Duration // the timeout of the connections and the writes (default 5s)
+}
+
+// SyslogSink is a result sink sending a RFC 5424 message for every batch (with the structured data
+// of the target, port, loss and latency) and for every state change of a target, over UDP, TCP
+// (with the octet counting framing of RFC 6587) or a unix socket (e.g. /dev/log). It is also a
+// StdLogger, so that the log messages of the library can go to syslog as well.
+type SyslogSink struct {
+	mutex    sync.Mutex
+	network  string
+	address  string
+	options  SyslogOptions
+	sdID     string
+	pid      int
+	tracker  *StateTracker
+	conn     net.Conn
+	framed   bool // octet counting (TCP)
+	newlines bool // newline terminated (unix stream sockets)
+}
+
+// NewSyslogSink creates a sink for a syslog URL: udp://host[:514], tcp://host[:601] or unix:///dev/log.
+// The connection is established at the first message and again after a failure.
+func NewSyslogSink(rawURL string, options SyslogOptions) (*SyslogSink, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	sink := &SyslogSink{network: target.Scheme, pid: os.Getpid(), tracker: NewStateTracker(0)}
+	switch target.Scheme {
+	case "udp", "tcp":
+		if target.Host == "" {
+			return nil, fmt.Errorf("no syslog host in %s", rawURL)
+		}
+		sink.address = target.Host
+		if target.Port() == "" {
+			port := "514"
+			if target.Scheme == "tcp" {
+				port = "601"
+			}
+			sink.address = net.JoinHostPort(target.Hostname(), port)
+		}
+	case "unix":
+		sink.address = target.Path
+		if sink.address == "" {
+			return nil, fmt.Errorf("no syslog socket in %s", rawURL)
+		}
+	default:
+		return nil, fmt.Errorf("unknown syslog transport %q (available: udp, tcp, unix)", target.Scheme)
+	}
+
+	if options.Facility == 0 {
+		options.Facility = syslogFacilities["local0"]
+	}
+	if options.AppName == "" {
+		options.AppName = "moreping"
+	}
+	if options.Hostname == "" {
+		options.Hostname, _ = os.Hostname()
+	}
+	if options.EnterpriseID == 0 {
+		options.EnterpriseID = syslogEnterpriseID
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = 5 * time.Second
+	}
+	sink.options = options
+	sink.sdID = fmt.Sprintf("moreping@%d", options.EnterpriseID)
+	return sink, nil
+}
+
+// WriteResult ignores the single calls.
+func (s *SyslogSink) WriteResult(result ProbeResult) error {
+	return nil
+}
+
+// WriteBatch sends the message of the batch, and that of the state change of its target if any.
+func (s *SyslogSink) WriteBatch(batch ProbeBatch) error {
+	params := batchParams(batch)
+	severity := syslogInfo
+	if batch.PctPcktLoss > 0 {
+		severity = syslogWarning
+	}
+	text := fmt.Sprintf("%s %s: %s%% loss, avg %s ms", batch.Probe, syslogTarget(batch.IpAddress, batch.Port),
+		strconv.FormatFloat(float64(batch.PctPcktLoss*100), 'f', -1, 32), formatMilliseconds(batch.AvgLatency))
+	if failures := syslogFailures(batch.Failures); failures != "" {
+		text += ", failures: " + failures
+	}
+	err := s.send(severity, batch.Timestamp, syslogBatchID, params, text)
+
+	if change, changed := s.tracker.Update(batch); changed {
+		severity := map[TargetState]int{StateUp: syslogNotice, StateDegraded: syslogWarning, StateDown: syslogError}[change.State]
+		previous := change.Previous
+		if previous == "" {
+			previous = "unknown"
+		}
+		params := append(batchParams(batch), [2]string{"previous_state", string(previous)}, [2]string{"state", string(change.State)})
+		text := fmt.Sprintf("%s %s is %s (was %s)", batch.Probe, syslogTarget(batch.IpAddress, batch.Port), change.State, previous)
+		if stateErr := s.send(severity, batch.Timestamp, syslogStateID, params, text); err == nil {
+			err = stateErr
+		}
+	}
+	return err
+}
+
+// Print sends a log message (as the StdLogger of the library).
+func (s *SyslogSink) Print(v ...interface{}) {
+	s.log(fmt.Sprint(v...))
+}
+
+// Printf sends a log message (as the StdLogger of the library).
+func (s *SyslogSink) Printf(format string, v ...interface{}) {
+	s.log(fmt.Sprintf(format, v...))
+}
+
+// Println sends a log message (as the StdLogger of the library).
+func (s *SyslogSink) Println(v ...interface{}) {
+	s.log(fmt.Sprintln(v...))
+}
+
+func (s *SyslogSink) log(text string) {
+	// the errors can not be logged
+	s.send(syslogInfo, time.Now(), syslogLogID, nil, strings.TrimRight(text, "\n"))
+}
+
+// Close closes the connection, if any.
+func (s *SyslogSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// batchParams are the structured data parameters of a batch.
+func batchParams(batch ProbeBatch) [][2]string {
+	params := [][2]string{
+		{"probe", batch.Probe},
+		{"target", batch.IpAddress},
+		{"port", fmt.Sprint(batch.Port)},
+		{"experiments", fmt.Sprint(batch.Experiments)},
+		{"loss_ratio", strconv.FormatFloat(float64(batch.PctPcktLoss), 'f', -1, 32)},
+		{"avg_latency_ms", formatMilliseconds(batch.AvgLatency)},
+	}
+	if len(batch.Latencies) > 0 {
+		params = append(params, [2]string{"min_latency_ms", formatMilliseconds(batch.MinLatency)},
+			[2]string{"max_latency_ms", formatMilliseconds(batch.MaxLatency)})
+	}
+	return params
+}
+
+func syslogTarget(ip string, port int) string {
+	if port == 0 {
+		return ip
+	}
+	return fmt.Sprintf("%s:%d", ip, port)
+}
+
+// syslogFailures lists the failure reasons of a batch, e.g. "i/o timeout (2), connection refused (1)".
+func syslogFailures(failures map[string]int) string {
+	reasons := map[string]int{}
+	for message, count := range failures {
+		reasons[failureReason(message)] += count
+	}
+	list := []string{}
+	for reason, count := range reasons {
+		list = append(list, fmt.Sprintf("%s (%d)", reason, count))
+	}
+	sort.Strings(list)
+	return strings.Join(list, ", ")
+}
+
+// format formats a RFC 5424 message, with a single structured data element (if any params).
+func (s *SyslogSink) format(severity int, timestamp time.Time, msgID string, params [][2]string, text string) []byte {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "<%d>1 %s %s %s %d %s ", s.options.Facility*8+severity, timestamp.Format("2006-01-02T15:04:05.000000Z07:00"),
+		syslogHeaderField(s.options.Hostname), syslogHeaderField(s.options.AppName), s.pid, msgID)
+	if len(params) == 0 {
+		buf.WriteString("-")
+	} else {
+		buf.WriteString("[" + s.sdID)
+		for _, param := range params {
+			fmt.Fprintf(buf, ` %s="%s"`, param[0], sdEscaper.Replace(param[1]))
+		}
+		buf.WriteString("]")
+	}
+	if text != "" {
+		buf.WriteString(" " + text)
+	}
+	return buf.Bytes()
+}
+
+// sdEscaper escapes the structured data parameter values.
+var sdEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// syslogHeaderField is a header field: printable ASCII without spaces, "-" if empty.
+func syslogHeaderField(field string) string {
+	field = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return '_'
+		}
+		return r
+	}, field)
+	if field == "" {
+		return "-"
+	}
+	return field
+}
+
+// send writes a message, connecting first if needed (and again at the next message after a failure).
+func (s *SyslogSink) send(severity int, timestamp time.Time, msgID string, params [][2]string, text string) error {
+	message := s.format(severity, timestamp, msgID, params, text)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.conn == nil {
+		if err := s.connect(); err != nil {
+			return err
+		}
+	}
+	switch {
+	case s.framed:
+		message = append([]byte(fmt.Sprintf("%d ", len(message))), message...)
+	case s.newlines:
+		message = append(message, '\n')
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(s.options.Timeout))
+	if _, err := s.conn.Write(message); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+// connect dials the syslog server, the unix sockets as datagram sockets first (as /dev/log usually is).
+func (s *SyslogSink) connect() error {
+	if s.network != "unix" {
+		conn, err := net.DialTimeout(s.network, s.address, s.options.Timeout)
+		if err != nil {
+			return err
+		}
+		s.conn, s.framed = conn, s.network == "tcp"
+		return nil
+	}
+	conn, err := net.DialTimeout("unixgram", s.address, s.options.Timeout)
+	if err == nil {
+		s.conn, s.newlines = conn, false
+		return nil
+	}
+	conn, err = net.DialTimeout("unix", s.address, s.options.Timeout)
+	if err != nil {
+		return err
+	}
+	s.conn, s.newlines = conn, true
+	return nil
+}
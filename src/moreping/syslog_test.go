@@ -0,0 +1,137 @@
+package moreping_test
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/tappoz/moreping/src/moreping"
+)
+
+// readDatagrams reads the given number of datagrams of a packet listener
+func readDatagrams(listener net.PacketConn, count int) []string {
+	datagrams := []string{}
+	buf := make([]byte, 64*1024)
+	listener.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for len(datagrams) < count {
+		n, _, err := listener.ReadFrom(buf)
+		Expect(err).NotTo(HaveOccurred())
+		datagrams = append(datagrams, string(buf[:n]))
+	}
+	return datagrams
+}
+
+var _ = Describe("Syslog", func() {
+
+	options := moreping.SyslogOptions{Hostname: "probe-1"}
+	pid := strconv.Itoa(os.Getpid())
+
+	It("should send the batches and the state changes over UDP", func() {
+		listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		defer listener.Close()
+		sink, err := moreping.NewSyslogSink("udp://"+listener.LocalAddr().String(), options)
+		Expect(err).NotTo(HaveOccurred())
+		defer sink.Close()
+
+		Expect(sink.WriteResult(moreping.ProbeResult{Probe: "tcp", IpAddress: "10.0.0.1", Port: 22})).To(Succeed())
+		Expect(sink.WriteBatch(stateBatch(0, 0))).To(Succeed())
+		Expect(sink.WriteBatch(stateBatch(1, 1))).To(Succeed())
+		messages := readDatagrams(listener, 3)
+		Expect(messages[0]).To(Equal("<134>1 2017-07-14T10:00:00.000000Z probe-1 moreping " + pid + ` batch ` +
+			`[moreping@32473 probe="tcp" target="10.0.0.1" port="22" experiments="4" loss_ratio="0" avg_latency_ms="2" min_latency_ms="0" max_latency_ms="0"] ` +
+			`tcp 10.0.0.1:22: 0% loss, avg 2 ms`))
+		Expect(messages[1]).To(HavePrefix("<132>1 2017-07-14T10:01:00.000000Z probe-1 moreping " + pid + " batch "))
+		Expect(messages[1]).To(ContainSubstring(` loss_ratio="0.25" `))
+		Expect(messages[1]).To(HaveSuffix(`tcp 10.0.0.1:22: 25% loss, avg 2 ms, failures: i/o timeout (1)`))
+		Expect(messages[2]).To(HavePrefix("<132>1 2017-07-14T10:01:00.000000Z probe-1 moreping " + pid + " state [moreping@32473 "))
+		Expect(messages[2]).To(ContainSubstring(` previous_state="up" state="degraded"]`))
+		Expect(messages[2]).To(HaveSuffix(`] tcp 10.0.0.1:22 is degraded (was up)`))
+	})
+
+	It("should frame the messages over TCP and reconnect", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		defer listener.Close()
+		frames := make(chan string, 10)
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				go func(conn net.Conn) {
+					defer conn.Close()
+					reader := bufio.NewReader(conn)
+					for {
+						length, err := reader.ReadString(' ')
+						if err != nil {
+							return
+						}
+						size, _ := strconv.Atoi(strings.TrimSpace(length))
+						frame := make([]byte, size)
+						if _, err := io.ReadFull(reader, frame); err != nil {
+							return
+						}
+						frames <- string(frame)
+					}
+				}(conn)
+			}
+		}()
+		sink, err := moreping.NewSyslogSink("tcp://"+listener.Addr().String(), moreping.SyslogOptions{Facility: 3, AppName: "my app", EnterpriseID: 99})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(sink.WriteBatch(moreping.ProbeBatch{Probe: "icmp", IpAddress: "10.0.0.2", Experiments: 2, PctPcktLoss: 1,
+			Timestamp: time.Date(2017, 7, 14, 10, 0, 0, 0, time.UTC), Failures: map[string]int{`said "no]"`: 2}})).To(Succeed())
+		Eventually(frames).Should(Receive(And(
+			HavePrefix("<28>1 2017-07-14T10:00:00.000000Z "),
+			ContainSubstring(" my_app "+pid+" batch [moreping@99 probe=\"icmp\" target=\"10.0.0.2\" port=\"0\" "),
+			HaveSuffix(`icmp 10.0.0.2: 100% loss, avg 0 ms, failures: said "no]" (2)`),
+		)))
+		Eventually(frames).Should(Receive(And(HavePrefix("<27>1 "), HaveSuffix("icmp 10.0.0.2 is down (was unknown)"))))
+
+		sink.Printf("Scheduled %d targets\n", 3)
+		Eventually(frames).Should(Receive(HaveSuffix(" log - Scheduled 3 targets")))
+		Expect(sink.Close()).To(Succeed())
+		sink.Println("after", "close")
+		Eventually(frames).Should(Receive(HaveSuffix(" log - after close")))
+	})
+
+	It("should escape the structured data", func() {
+		dir, err := ioutil.TempDir("", "moreping-syslog")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dir)
+		socket := filepath.Join(dir, "log")
+		listener, err := net.ListenPacket("unixgram", socket)
+		Expect(err).NotTo(HaveOccurred())
+		defer listener.Close()
+		sink, err := moreping.NewSyslogSink("unix://"+socket, options)
+		Expect(err).NotTo(HaveOccurred())
+		defer sink.Close()
+
+		Expect(sink.WriteBatch(moreping.ProbeBatch{Probe: `we"ird]\`, IpAddress: "10.0.0.3", Experiments: 1})).To(Succeed())
+		Expect(readDatagrams(listener, 1)[0]).To(ContainSubstring(`[moreping@32473 probe="we\"ird\]\\" target="10.0.0.3"`))
+	})
+
+	It("should refuse the invalid URLs", func() {
+		for _, url := range []string{"syslog.example.com:514", "http://syslog.example.com", "udp://", "unix://"} {
+			_, err := moreping.NewSyslogSink(url, options)
+			Expect(err).To(HaveOccurred(), url)
+		}
+		facility, err := moreping.SyslogFacility("LOCAL7")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(facility).To(Equal(23))
+		for _, name := range []string{"local8", "kern"} {
+			_, err = moreping.SyslogFacility(name)
+			Expect(err).To(HaveOccurred(), name)
+		}
+	})
+})
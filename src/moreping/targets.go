@@ -0,0 +1,161 @@
+package moreping
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// maxExpandedTargets limits how many IP addresses a list of targets can expand to
+// (a /16 network), so that a typo like 10.0.0.0/8 does not exhaust the memory.
+const maxExpandedTargets = 65536
+
+// ExpandTargets expands a list of targets into a flat list of IP addresses (or host names).
+// Each target can be an IP address, a host name, a CIDR block (e.g. 10.0.0.0/24, without
+// the network and broadcast addresses) or an IPv4 range (e.g. 10.0.0.1-50 or 10.0.0.1-10.0.0.50).
+// The exclusions use the same syntax. The order is preserved and the duplicates removed.
+func ExpandTargets(targets []string, exclusions []string) ([]string, error) {
+	excluded := map[string]bool{}
+	for _, exclusion := range exclusions {
+		ips, err := expandTarget(exclusion)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			excluded[ip] = true
+		}
+	}
+
+	expanded := []string{}
+	for _, target := range targets {
+		ips, err := expandTarget(target)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			if excluded[ip] {
+				continue
+			}
+			excluded[ip] = true // no duplicates
+			expanded = append(expanded, ip)
+		}
+		if len(expanded) > maxExpandedTargets {
+			return nil, fmt.Errorf("too many targets: more than %d IP addresses", maxExpandedTargets)
+		}
+	}
+	return expanded, nil
+}
+
+func expandTarget(target string) ([]string, error) {
+	target = strings.TrimSpace(target)
+	switch {
+	case target == "":
+		return nil, nil
+	case strings.Contains(target, "/"):
+		return expandCIDR(target)
+	case strings.Contains(target, "-"):
+		dash := strings.Index(target, "-")
+		if first := net.ParseIP(target[:dash]).To4(); first != nil {
+			return expandRange(target, first, target[dash+1:])
+		}
+	}
+	// a single IP address or a host name (which can contain dashes)
+	return []string{target}, nil
+}
+
+func expandCIDR(target string) ([]string, error) {
+	ip, ipNet, err := net.ParseCIDR(target)
+	if err != nil {
+		return nil, err
+	}
+	if ip.To4() == nil {
+		return nil, fmt.Errorf("only IPv4 CIDR blocks are supported: %s", target)
+	}
+	ones, bits := ipNet.Mask.Size()
+	if bits-ones > 16 {
+		return nil, fmt.Errorf("CIDR block too large (at most a /16): %s", target)
+	}
+	first := ipToUint32(ipNet.IP.To4())
+	last := first | (1<<uint(bits-ones) - 1)
+	if bits-ones >= 2 {
+		// skip the network and the broadcast addresses
+		first, last = first+1, last-1
+	}
+	return uint32Range(first, last), nil
+}
+
+func expandRange(target string, first net.IP, end string) ([]string, error) {
+	var last net.IP
+	if strings.Contains(end, ".") {
+		last = net.ParseIP(end).To4()
+	} else {
+		last = net.ParseIP(fmt.Sprintf("%d.%d.%d.%s", first[0], first[1], first[2], end)).To4()
+	}
+	if last == nil {
+		return nil, fmt.Errorf("invalid IP range: %s", target)
+	}
+	from, to := ipToUint32(first), ipToUint32(last)
+	if from > to {
+		return nil, fmt.Errorf("invalid IP range: %s", target)
+	}
+	if to-from >= maxExpandedTargets {
+		return nil, fmt.Errorf("IP range too large: %s", target)
+	}
+	return uint32Range(from, to), nil
+}
+
+func ipToUint32(ip net.IP) uint32 {
+	return binary.BigEndian.Uint32(ip.To4())
+}
+
+func uint32Range(from uint32, to uint32) []string {
+	ips := make([]string, 0, to-from+1)
+	for n := uint64(from); n <= uint64(to); n++ {
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, uint32(n))
+		ips = append(ips, ip.String())
+	}
+	return ips
+}
+
+// DiscoverHosts sweeps a list of IP addresses with a prober (e.g. ICMP, or TCP on some
+// common ports) returning the ones which are alive, in the same order. A host is alive
+// if at least one call is successful, or (for TCP) if it actively refuses a connection.
+// Up to `concurrency` hosts are probed at the same time.
+func DiscoverHosts(prober Prober, ips []string, ports []int, concurrency int) []string {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	alive := make([]bool, len(ips))
+	semaphore := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for idx, ip := range ips {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(idx int, ip string) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			for _, target := range Targets([]string{ip}, ports) {
+				result := prober.Probe(target)
+				if result.Success || result.Details["port_state"] == string(PortClosed) {
+					alive[idx] = true
+					return
+				}
+			}
+		}(idx, ip)
+	}
+	wg.Wait()
+
+	aliveIPs := []string{}
+	for idx, ip := range ips {
+		if alive[idx] {
+			aliveIPs = append(aliveIPs, ip)
+		}
+	}
+	Logger.Printf("Discovered %d alive hosts out of %d\n", len(aliveIPs), len(ips))
+	return aliveIPs
+}
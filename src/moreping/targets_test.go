@@ -0,0 +1,85 @@
+package moreping_test
+
+import (
+	"net"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/tappoz/moreping/src/moreping"
+)
+
+// aliveProber answers successfully only for the given IP addresses
+type aliveProber struct {
+	alive map[string]bool
+}
+
+func (a *aliveProber) Name() string {
+	return "alive"
+}
+
+func (a *aliveProber) Probe(target moreping.Target) moreping.ProbeResult {
+	return moreping.ProbeResult{Probe: "alive", IpAddress: target.IpAddress, Port: target.Port, Timestamp: time.Now(), Success: a.alive[target.IpAddress]}
+}
+
+var _ = Describe("Targets", func() {
+
+	Describe("Expanding the targets", func() {
+
+		It("should expand CIDR blocks without the network and broadcast addresses", func() {
+			ips, err := moreping.ExpandTargets([]string{"10.0.0.0/30", "10.0.1.8/31", "10.0.2.1/32"}, nil)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ips).To(Equal([]string{"10.0.0.1", "10.0.0.2", "10.0.1.8", "10.0.1.9", "10.0.2.1"}))
+		})
+
+		It("should expand IP ranges", func() {
+			ips, err := moreping.ExpandTargets([]string{"10.0.0.1-3", "10.0.0.254-10.0.1.1"}, nil)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ips).To(Equal([]string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.254", "10.0.0.255", "10.0.1.0", "10.0.1.1"}))
+		})
+
+		It("should keep the single IP addresses and host names, without duplicates", func() {
+			ips, err := moreping.ExpandTargets([]string{"www.my-site.com", "10.0.0.1", "10.0.0.1-2", "www.my-site.com"}, nil)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ips).To(Equal([]string{"www.my-site.com", "10.0.0.1", "10.0.0.2"}))
+		})
+
+		It("should remove the exclusions", func() {
+			ips, err := moreping.ExpandTargets([]string{"10.0.0.0/29"}, []string{"10.0.0.1", "10.0.0.4-5"})
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ips).To(Equal([]string{"10.0.0.2", "10.0.0.3", "10.0.0.6"}))
+		})
+
+		It("should refuse invalid or too large targets", func() {
+			for _, target := range []string{"10.0.0.0/33", "10.0.0.0/8", "fe80::/64", "10.0.0.9-1", "10.0.0.1-300"} {
+				_, err := moreping.ExpandTargets([]string{target}, nil)
+				Expect(err).To(HaveOccurred(), target)
+			}
+		})
+	})
+
+	Describe("Discovering the hosts", func() {
+
+		It("should report the alive hosts in order", func() {
+			prober := &aliveProber{alive: map[string]bool{"10.0.0.2": true, "10.0.0.4": true}}
+			ips, _ := moreping.ExpandTargets([]string{"10.0.0.1-5"}, nil)
+
+			Expect(moreping.DiscoverHosts(prober, ips, nil, 2)).To(Equal([]string{"10.0.0.2", "10.0.0.4"}))
+		})
+
+		It("should consider alive the hosts refusing TCP connections", func() {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			closedPort := listener.Addr().(*net.TCPAddr).Port
+			listener.Close()
+			prober, err := moreping.NewProber(moreping.TCPProbe, moreping.ProbeConfig{Timeout: 300 * time.Millisecond})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(moreping.DiscoverHosts(prober, []string{"127.0.0.1"}, []int{closedPort}, 10)).To(Equal([]string{"127.0.0.1"}))
+		})
+	})
+})
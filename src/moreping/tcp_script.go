@@ -0,0 +1,167 @@
+package moreping
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxScriptBuffer is the maximum amount of unmatched bytes kept while expecting.
+const maxScriptBuffer = 64 * 1024
+
+// TcpStep is a step of a send/expect script run after a successful TCP dial:
+// the bytes are sent (if any) then the regexp is waited for (if any).
+type TcpStep struct {
+	Send    string
+	Expect  *regexp.Regexp
+	Timeout time.Duration // 0 means the timeout of the TCP pinger
+}
+
+// ParseTcpStep parses a step of a send/expect script from its textual form:
+// `send:<bytes>` or `expect:<regexp>`, optionally with a timeout as in `expect[5s]:^220`.
+// The bytes to send support the escape sequences \r, \n, \t, \\, \" and \xNN (e.g. `send:QUIT\r\n`).
+func ParseTcpStep(spec string) (TcpStep, error) {
+	step := TcpStep{}
+	sep := strings.Index(spec, ":")
+	if sep < 0 {
+		return step, fmt.Errorf("invalid step %q: expected send:<bytes> or expect:<regexp>", spec)
+	}
+	kind, arg := spec[:sep], spec[sep+1:]
+	if open := strings.Index(kind, "["); open >= 0 && strings.HasSuffix(kind, "]") {
+		timeout, err := time.ParseDuration(kind[open+1 : len(kind)-1])
+		if err != nil {
+			return step, fmt.Errorf("invalid step %q: %s", spec, err)
+		}
+		step.Timeout = timeout
+		kind = kind[:open]
+	}
+	switch kind {
+	case "send":
+		send, err := unescapeStep(arg)
+		if err != nil {
+			return step, fmt.Errorf("invalid step %q: %s", spec, err)
+		}
+		step.Send = send
+	case "expect":
+		expect, err := regexp.Compile(arg)
+		if err != nil {
+			return step, fmt.Errorf("invalid step %q: %s", spec, err)
+		}
+		step.Expect = expect
+	default:
+		return step, fmt.Errorf("invalid step %q: unknown kind %q", spec, kind)
+	}
+	return step, nil
+}
+
+// unescapeStep replaces the escape sequences of the bytes to send.
+func unescapeStep(arg string) (string, error) {
+	unescaped := make([]byte, 0, len(arg))
+	for i := 0; i < len(arg); i++ {
+		if arg[i] != '\\' {
+			unescaped = append(unescaped, arg[i])
+			continue
+		}
+		if i+1 == len(arg) {
+			return "", fmt.Errorf("trailing backslash")
+		}
+		i++
+		switch arg[i] {
+		case 'r':
+			unescaped = append(unescaped, '\r')
+		case 'n':
+			unescaped = append(unescaped, '\n')
+		case 't':
+			unescaped = append(unescaped, '\t')
+		case '\\', '"':
+			unescaped = append(unescaped, arg[i])
+		case 'x':
+			if i+3 > len(arg) {
+				return "", fmt.Errorf("invalid escape sequence \\%s", arg[i:])
+			}
+			b, err := strconv.ParseUint(arg[i+1:i+3], 16, 8)
+			if err != nil {
+				return "", fmt.Errorf("invalid escape sequence \\%s", arg[i:i+3])
+			}
+			unescaped = append(unescaped, byte(b))
+			i += 2
+		default:
+			return "", fmt.Errorf("invalid escape sequence \\%c", arg[i])
+		}
+	}
+	return string(unescaped), nil
+}
+
+// runTcpScript runs the send/expect steps on an established connection
+// updating the TCP call with the time to banner and the eventual failed step.
+// A match consumes the line holding it, so that the next step (e.g. `^221`) starts at the next line.
+func runTcpScript(conn net.Conn, script []TcpStep, defaultTimeout time.Duration, start time.Time, tcpCall *TcpCall) {
+	var received []byte
+	skipLine := false // the rest of the matched line is still to be received
+	chunk := make([]byte, 4096)
+	fail := func(idx int, format string, v ...interface{}) {
+		tcpCall.Success = false
+		tcpCall.FailedStep = idx + 1
+		tcpCall.Message = fmt.Sprintf("step %d: ", idx+1) + fmt.Sprintf(format, v...)
+	}
+
+	for idx, step := range script {
+		timeout := step.Timeout
+		if timeout == 0 {
+			timeout = defaultTimeout
+		}
+		conn.SetDeadline(time.Now().Add(timeout))
+
+		if step.Send != "" {
+			if _, err := conn.Write([]byte(step.Send)); err != nil {
+				fail(idx, "unable to send %q: %s", step.Send, err)
+				return
+			}
+		}
+		if step.Expect == nil {
+			continue
+		}
+		for {
+			if loc := step.Expect.FindIndex(received); loc != nil {
+				if loc[1] > 0 && received[loc[1]-1] == '\n' {
+					received = received[loc[1]:]
+				} else if eol := bytes.IndexByte(received[loc[1]:], '\n'); eol >= 0 {
+					received = received[loc[1]+eol+1:]
+				} else {
+					received, skipLine = received[:0], true
+				}
+				break
+			}
+			n, err := conn.Read(chunk)
+			if n > 0 {
+				if tcpCall.BannerLatency == 0 {
+					tcpCall.BannerLatency = time.Now().Sub(start)
+				}
+				received = append(received, chunk[:n]...)
+				if skipLine {
+					if eol := bytes.IndexByte(received, '\n'); eol >= 0 {
+						received, skipLine = received[eol+1:], false
+					} else {
+						received = received[:0]
+					}
+				}
+				if len(received) > maxScriptBuffer {
+					received = received[len(received)-maxScriptBuffer:]
+				}
+				continue
+			}
+			if err != nil {
+				tail := received
+				if len(tail) > 80 {
+					tail = tail[len(tail)-80:]
+				}
+				fail(idx, "expecting %q: %s (received %q)", step.Expect.String(), err, tail)
+				return
+			}
+		}
+	}
+}
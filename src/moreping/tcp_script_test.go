@@ -0,0 +1,146 @@
+package moreping_test
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/tappoz/moreping/src/moreping"
+)
+
+// standInServer accepts connections on localhost handling each of them with the given function
+func standInServer(handle func(conn net.Conn)) (net.Listener, int) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).NotTo(HaveOccurred())
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				handle(conn)
+			}(conn)
+		}
+	}()
+	return listener, listener.Addr().(*net.TCPAddr).Port
+}
+
+// smtpLikeServer accepts connections sending a banner and answering to QUIT
+func smtpLikeServer(banner string) (net.Listener, int) {
+	return standInServer(func(conn net.Conn) {
+		conn.Write([]byte(banner))
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if err == nil && strings.TrimSpace(line) == "QUIT" {
+			conn.Write([]byte("221 Bye\r\n"))
+		}
+	})
+}
+
+func mustParseSteps(specs ...string) []moreping.TcpStep {
+	steps := []moreping.TcpStep{}
+	for _, spec := range specs {
+		step, err := moreping.ParseTcpStep(spec)
+		Expect(err).NotTo(HaveOccurred())
+		steps = append(steps, step)
+	}
+	return steps
+}
+
+var _ = Describe("TCP script", func() {
+
+	localhostIP := "127.0.0.1"
+	tcpTimeout := 1 * time.Second
+
+	Describe("Parsing the steps", func() {
+
+		It("should parse the send steps with escape sequences and timeout", func() {
+			step, err := moreping.ParseTcpStep(`send[2s]:QUIT\r\n`)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(step.Send).To(Equal("QUIT\r\n"))
+			Expect(step.Expect).To(BeNil())
+			Expect(step.Timeout).To(Equal(2 * time.Second))
+		})
+
+		It("should unescape the quotes, backslashes and hexadecimal bytes to send", func() {
+			for spec, send := range map[string]string{
+				`send:say "hi"`:       `say "hi"`,
+				`send:say \"hi\"`:     `say "hi"`,
+				`send:C:\\`:           `C:\`,
+				`send:\x00\x1bq\tend`: "\x00\x1bq\tend",
+			} {
+				step, err := moreping.ParseTcpStep(spec)
+				Expect(err).NotTo(HaveOccurred(), spec)
+				Expect(step.Send).To(Equal(send), spec)
+			}
+			for _, spec := range []string{`send:C:\`, `send:\x1`, `send:\xzz`, `send:\q`} {
+				_, err := moreping.ParseTcpStep(spec)
+				Expect(err).To(HaveOccurred(), spec)
+			}
+		})
+
+		It("should parse the expect steps", func() {
+			step, err := moreping.ParseTcpStep(`expect:^220 .*ESMTP`)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(step.Send).To(BeEmpty())
+			Expect(step.Expect.String()).To(Equal(`^220 .*ESMTP`))
+			Expect(step.Timeout).To(BeZero())
+		})
+
+		It("should refuse unknown kinds of steps, invalid regexps and invalid timeouts", func() {
+			for _, spec := range []string{"wait:1s", "expect:(", "expect[soon]:220", "QUIT"} {
+				_, err := moreping.ParseTcpStep(spec)
+				Expect(err).To(HaveOccurred())
+			}
+		})
+	})
+
+	Describe("Running the script after dialing", func() {
+
+		It("should succeed when the server sends the expected banner and answers to the commands", func() {
+			listener, port := smtpLikeServer("220 localhost ESMTP moreping\r\n")
+			defer listener.Close()
+			options := moreping.TCPOptions{Script: mustParseSteps(`expect:^220 .*ESMTP`, `send:QUIT\r\n`, `expect:^221`)}
+			tcpPinger := moreping.NewTCPPingerWithOptions([]int{port}, tcpTimeout, options, nil)
+
+			tcpCallMsg := tcpPinger.DialIP(localhostIP, port)
+
+			Expect(tcpCallMsg.Success).To(Equal(true))
+			Expect(tcpCallMsg.FailedStep).To(Equal(0))
+			Expect(tcpCallMsg.BannerLatency).Should(BeNumerically(">=", tcpCallMsg.Latency))
+			Expect(tcpCallMsg.BannerLatency).Should(BeNumerically("<=", tcpTimeout))
+		})
+
+		It("should report the failed step when the banner does not match", func() {
+			listener, port := smtpLikeServer("554 go away\r\n")
+			defer listener.Close()
+			options := moreping.TCPOptions{Script: mustParseSteps(`expect[200ms]:^220`, `send:QUIT\r\n`)}
+			tcpPinger := moreping.NewTCPPingerWithOptions([]int{port}, tcpTimeout, options, nil)
+
+			tcpCallMsg := tcpPinger.DialIP(localhostIP, port)
+
+			Expect(tcpCallMsg.Success).To(Equal(false))
+			Expect(tcpCallMsg.FailedStep).To(Equal(1))
+			Expect(tcpCallMsg.Message).To(HavePrefix("step 1: expecting"))
+			Expect(tcpCallMsg.BannerLatency).Should(BeNumerically(">", 0))
+		})
+
+		It("should count the failed scripts as lost packets in a batch", func() {
+			listener, port := smtpLikeServer("554 go away\r\n")
+			defer listener.Close()
+			options := moreping.TCPOptions{Script: mustParseSteps(`expect[100ms]:^220`)}
+			tcpBatchPinger := moreping.NewTCPBatchPingerWithOptions([]int{port}, tcpTimeout, options, nil)
+
+			tcpBatch := tcpBatchPinger.DialBatchIP(localhostIP, port, 3)
+
+			Expect(tcpBatch.Expertiments).To(Equal(3))
+			Expect(tcpBatch.PctPcktLoss).To(Equal(float32(1.0)))
+		})
+	})
+})
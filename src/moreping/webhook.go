@@ -0,0 +1,190 @@
+package moreping
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// WebhookOptions configures the webhook notifications. The zero values are replaced by the defaults.
+type WebhookOptions struct {
+	Template    string        // the text/template of the body, from the JSONStateChange record (its JSON by default)
+	ContentType string        // the content type of the body (default application/json)
+	Timeout     time.Duration // the timeout of each attempt (default 5s)
+	Retries     int           // the attempts after a failed one (default 3, -1 for none)
+	RetryDelay  time.Duration // the wait before the first retry, doubled at every retry (default 1s)
+	Recent      int           // the recent batches in the payloads (default 5)
+	QueueSize   int           // the notifications waiting to be sent, the newest are dropped (default 100)
+}
+
+// WebhookSink is a result sink posting a notification to every webhook URL when a target
+// transitions between the up, degraded and down states. The notifications are sent in order
+// in the background (not to slow down the probes), and retried on network errors, server
+// errors and "429 Too Many Requests" answers.
+type WebhookSink struct {
+	urls     []string
+	options  WebhookOptions
+	template *template.Template
+	client   *http.Client
+	tracker  *StateTracker
+	mutex    sync.Mutex
+	queue    chan JSONStateChange
+	done     chan struct{}
+	closed   bool
+}
+
+// notificationFuncs are the functions of the webhook and email templates, e.g. to write a Slack message:
+//
+//	{"text": {{json (printf "%s is %s (%.0f%% loss)" .Target .State (percent .Batch.LossRatio))}}}
+var notificationFuncs = template.FuncMap{
+	"json": func(value interface{}) (string, error) {
+		data, err := json.Marshal(value)
+		return string(data), err
+	},
+	"percent": func(ratio float32) float64 {
+		return float64(ratio) * 100
+	},
+	"reason": failureReason,
+}
+
+// NewWebhookSink creates a sink notifying the URLs, failing on an invalid template.
+// Close it to send the pending notifications.
+func NewWebhookSink(urls []string, options WebhookOptions) (*WebhookSink, error) {
+	if options.ContentType == "" {
+		options.ContentType = "application/json"
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = 5 * time.Second
+	}
+	if options.Retries == 0 {
+		options.Retries = 3
+	}
+	if options.RetryDelay <= 0 {
+		options.RetryDelay = time.Second
+	}
+	if options.QueueSize <= 0 {
+		options.QueueSize = 100
+	}
+	sink := &WebhookSink{
+		urls:    urls,
+		options: options,
+		client:  &http.Client{Timeout: options.Timeout},
+		tracker: NewStateTracker(options.Recent),
+		queue:   make(chan JSONStateChange, options.QueueSize),
+		done:    make(chan struct{}),
+	}
+	if options.Template != "" {
+		tmpl, err := template.New("webhook").Funcs(notificationFuncs).Parse(options.Template)
+		if err != nil {
+			return nil, err
+		}
+		sink.template = tmpl
+	}
+	go sink.run()
+	return sink, nil
+}
+
+// WriteResult ignores the single calls: the states come from the batches.
+func (w *WebhookSink) WriteResult(result ProbeResult) error {
+	return nil
+}
+
+// WriteBatch queues a notification when the state of the target changed.
+func (w *WebhookSink) WriteBatch(batch ProbeBatch) error {
+	change, changed := w.tracker.Update(batch)
+	if !changed {
+		return nil
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return nil
+	}
+	select {
+	case w.queue <- NewJSONStateChange(change):
+		return nil
+	default:
+		return fmt.Errorf("too many pending webhook notifications, dropped %s of %s", change.State, change.IpAddress)
+	}
+}
+
+// Close sends the pending notifications and stops the sink
+// (the batches written afterwards, e.g. by the probes still running, are dropped).
+func (w *WebhookSink) Close() error {
+	w.mutex.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mutex.Unlock()
+	<-w.done
+	return nil
+}
+
+func (w *WebhookSink) run() {
+	defer close(w.done)
+	for change := range w.queue {
+		body, err := w.body(change)
+		if err != nil {
+			Logger.Printf("Unable to build the webhook payload: %s\n", err)
+			continue
+		}
+		for _, url := range w.urls {
+			if err := w.deliver(url, body); err != nil {
+				Logger.Printf("Unable to notify %s of %s %s: %s\n", url, change.Target, change.State, err)
+			}
+		}
+	}
+}
+
+// body is the payload of a notification, from the template if any.
+func (w *WebhookSink) body(change JSONStateChange) ([]byte, error) {
+	if w.template == nil {
+		return json.Marshal(change)
+	}
+	buf := &bytes.Buffer{}
+	err := w.template.Execute(buf, change)
+	return buf.Bytes(), err
+}
+
+// deliver posts the payload, retrying the failures which can be retried.
+func (w *WebhookSink) deliver(url string, body []byte) error {
+	delay := w.options.RetryDelay
+	for attempt := 0; ; attempt++ {
+		retry, err := w.post(url, body)
+		if err == nil || !retry || attempt >= w.options.Retries {
+			return err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// post sends the payload once, telling whether a failure can be retried
+// (the client errors are not, as the same payload would fail again).
+func (w *WebhookSink) post(url string, body []byte) (bool, error) {
+	request, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", w.options.ContentType)
+	request.Header.Set("User-Agent", "moreping")
+	response, err := w.client.Do(request)
+	if err != nil {
+		return true, err
+	}
+	defer response.Body.Close()
+	answer, _ := ioutil.ReadAll(io.LimitReader(response.Body, 512))
+	if response.StatusCode/100 == 2 {
+		return false, nil
+	}
+	retry := response.StatusCode >= 500 || response.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("%s: %s", response.Status, strings.TrimSpace(string(answer)))
+}
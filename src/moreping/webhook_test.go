@@ -0,0 +1,184 @@
+package moreping_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/tappoz/moreping/src/moreping"
+)
+
+// webhookStandIn is a webhook endpoint failing with the given status codes first
+type webhookStandIn struct {
+	mutex    sync.Mutex
+	failures []int
+	attempts int
+	bodies   []string
+	headers  []http.Header
+}
+
+func (s *webhookStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	body, _ := ioutil.ReadAll(r.Body)
+	s.attempts++
+	if len(s.failures) > 0 {
+		status := s.failures[0]
+		s.failures = s.failures[1:]
+		http.Error(w, "stand-in failure", status)
+		return
+	}
+	s.bodies = append(s.bodies, string(body))
+	s.headers = append(s.headers, r.Header)
+}
+
+func (s *webhookStandIn) received() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string{}, s.bodies...)
+}
+
+// stateBatch is a batch of 4 calls of 10.0.0.1:22 with the given failures
+func stateBatch(minute int, failures int) moreping.ProbeBatch {
+	batch := moreping.ProbeBatch{Probe: "tcp", IpAddress: "10.0.0.1", Port: 22, Experiments: 4,
+		Timestamp: time.Date(2017, 7, 14, 10, minute, 0, 0, time.UTC), PctPcktLoss: float32(failures) / 4,
+		AvgLatency: 2 * time.Millisecond, Failures: map[string]int{}}
+	for call := failures; call < 4; call++ {
+		batch.Latencies = append(batch.Latencies, 2*time.Millisecond)
+	}
+	if failures > 0 {
+		batch.Failures["dial tcp 10.0.0.1:22: i/o timeout"] = failures
+	}
+	return batch
+}
+
+var _ = Describe("State changes", func() {
+
+	It("should tell the transitions of the targets", func() {
+		tracker := moreping.NewStateTracker(2)
+		_, changed := tracker.Update(stateBatch(0, 0))
+		Expect(changed).To(BeFalse(), "a target up from the start")
+		_, changed = tracker.Update(stateBatch(1, 0))
+		Expect(changed).To(BeFalse())
+
+		change, changed := tracker.Update(stateBatch(2, 1))
+		Expect(changed).To(BeTrue())
+		Expect(change.Previous).To(Equal(moreping.StateUp))
+		Expect(change.State).To(Equal(moreping.StateDegraded))
+		Expect(change.Since).To(Equal(stateBatch(0, 0).Timestamp))
+		Expect(change.Batches).To(HaveLen(2))
+		Expect(change.Last().Timestamp).To(Equal(stateBatch(2, 1).Timestamp))
+
+		change, changed = tracker.Update(stateBatch(3, 4))
+		Expect(changed).To(BeTrue())
+		Expect(change.State).To(Equal(moreping.StateDown))
+		Expect(change.Failures()).To(Equal(map[string]int{"i/o timeout": 5}))
+
+		change, changed = tracker.Update(moreping.ProbeBatch{Probe: "icmp", IpAddress: "10.0.0.2", PctPcktLoss: 1})
+		Expect(changed).To(BeTrue(), "a target down from the start")
+		Expect(change.Previous).To(BeEmpty())
+	})
+})
+
+var _ = Describe("Webhooks", func() {
+
+	It("should post the state changes as JSON", func() {
+		standIn := &webhookStandIn{}
+		server := httptest.NewServer(standIn)
+		defer server.Close()
+		sink, err := moreping.NewWebhookSink([]string{server.URL}, moreping.WebhookOptions{})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(sink.WriteResult(moreping.ProbeResult{Probe: "tcp", IpAddress: "10.0.0.1", Port: 22})).To(Succeed())
+		for minute, failures := range []int{0, 0, 4, 4, 0} {
+			Expect(sink.WriteBatch(stateBatch(minute, failures))).To(Succeed())
+		}
+		Expect(sink.Close()).To(Succeed())
+
+		bodies := standIn.received()
+		Expect(bodies).To(HaveLen(2))
+		Expect(standIn.headers[0].Get("Content-Type")).To(Equal("application/json"))
+		down := moreping.JSONStateChange{}
+		Expect(json.Unmarshal([]byte(bodies[0]), &down)).To(Succeed())
+		Expect(down.Kind).To(Equal("state_change"))
+		Expect(down.Target).To(Equal("10.0.0.1"))
+		Expect(down.Port).To(Equal(22))
+		Expect(down.PreviousState).To(Equal("up"))
+		Expect(down.State).To(Equal("down"))
+		Expect(*down.Since).To(Equal(stateBatch(0, 0).Timestamp))
+		Expect(down.Batch.LossRatio).To(BeEquivalentTo(1))
+		Expect(down.Recent).To(HaveLen(3))
+		Expect(down.Failures).To(Equal(map[string]int{"i/o timeout": 4}))
+
+		up := moreping.JSONStateChange{}
+		Expect(json.Unmarshal([]byte(bodies[1]), &up)).To(Succeed())
+		Expect(up.PreviousState).To(Equal("down"))
+		Expect(up.State).To(Equal("up"))
+		Expect(up.Failures).To(Equal(map[string]int{"i/o timeout": 8}))
+	})
+
+	It("should write the payloads from the template", func() {
+		standIn := &webhookStandIn{}
+		server := httptest.NewServer(standIn)
+		defer server.Close()
+		sink, err := moreping.NewWebhookSink([]string{server.URL}, moreping.WebhookOptions{
+			Template: `{"text": {{json (printf "%s:%d is %s" .Target .Port .State)}}, "loss": {{.Batch.LossRatio}}}`,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sink.WriteBatch(stateBatch(0, 1))).To(Succeed())
+		Expect(sink.Close()).To(Succeed())
+		Expect(standIn.received()).To(Equal([]string{`{"text": "10.0.0.1:22 is degraded", "loss": 0.25}`}))
+
+		_, err = moreping.NewWebhookSink([]string{server.URL}, moreping.WebhookOptions{Template: "{{.Target"})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should retry the server errors only", func() {
+		standIn := &webhookStandIn{failures: []int{http.StatusBadGateway, http.StatusTooManyRequests}}
+		server := httptest.NewServer(standIn)
+		defer server.Close()
+		sink, _ := moreping.NewWebhookSink([]string{server.URL}, moreping.WebhookOptions{RetryDelay: time.Millisecond})
+		Expect(sink.WriteBatch(stateBatch(0, 4))).To(Succeed())
+		Expect(sink.Close()).To(Succeed())
+		Expect(standIn.attempts).To(Equal(3))
+		Expect(standIn.received()).To(HaveLen(1))
+
+		standIn = &webhookStandIn{failures: []int{http.StatusBadRequest}}
+		server = httptest.NewServer(standIn)
+		defer server.Close()
+		sink, _ = moreping.NewWebhookSink([]string{server.URL}, moreping.WebhookOptions{RetryDelay: time.Millisecond})
+		Expect(sink.WriteBatch(stateBatch(0, 4))).To(Succeed())
+		Expect(sink.Close()).To(Succeed())
+		Expect(standIn.attempts).To(Equal(1))
+		Expect(standIn.received()).To(BeEmpty())
+	})
+
+	It("should drop the batches written after being closed", func() {
+		standIn := &webhookStandIn{}
+		server := httptest.NewServer(standIn)
+		defer server.Close()
+		sink, _ := moreping.NewWebhookSink([]string{server.URL}, moreping.WebhookOptions{})
+		Expect(sink.WriteBatch(stateBatch(0, 4))).To(Succeed())
+		Expect(sink.Close()).To(Succeed())
+		Expect(sink.WriteBatch(stateBatch(1, 0))).To(Succeed())
+		Expect(sink.Close()).To(Succeed())
+		Expect(standIn.received()).To(HaveLen(1))
+	})
+
+	It("should give up on the slow endpoints", func() {
+		slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(200 * time.Millisecond)
+		}))
+		defer slow.Close()
+		sink, _ := moreping.NewWebhookSink([]string{slow.URL}, moreping.WebhookOptions{Timeout: 20 * time.Millisecond, Retries: -1})
+		Expect(sink.WriteBatch(stateBatch(0, 4))).To(Succeed())
+		start := time.Now()
+		Expect(sink.Close()).To(Succeed())
+		Expect(time.Since(start)).To(BeNumerically("<", 150*time.Millisecond))
+	})
+})